@@ -4,41 +4,369 @@ import (
   "flag"
   "fmt"
   "log"
+  "os"
+  "strings"
 
   "github.com/hajimehoshi/ebiten/v2"
 
+  "jfeintzeig/chip8/internal/config"
+  "jfeintzeig/chip8/internal/coverage"
   "jfeintzeig/chip8/internal/cpu"
   "jfeintzeig/chip8/internal/display"
+  "jfeintzeig/chip8/internal/keymap"
+  "jfeintzeig/chip8/internal/library"
+  "jfeintzeig/chip8/internal/romdb"
+  "jfeintzeig/chip8/internal/screen"
+  "jfeintzeig/chip8/internal/sound"
 )
 
 var (
   debug *bool
   modern *bool
+  keyPress *bool
   file *string
+  listing *string
+  lcov *string
+  symbols *string
+  scale *int
+  fullscreen *bool
+  fit *bool
+  palette *string
+  configFile *string
+  persistence *string
+  decay *float64
+  persistenceFrames *int
+  record *string
+  recordAudio *bool
+  captureScale *int
+  frequency *float64
+  waveform *string
+  volume *float64
+  mute *bool
+  layout *string
+  gamepadProfile *string
+  deadZone *float64
+  showKeypad *bool
+  romDB *string
+  platformName *string
+  libraryDir *string
 )
 
 func init() {
-  file = flag.String("file","data/ibm_logo.ch8","path to file to load")
+  file = flag.String("file","data/ibm_logo.ch8","path to file to load: - for stdin, a .zip or a file inside one like games.zip/pong.ch8, or an Octo cartridge .gif")
   debug = flag.Bool("debug",false,"set true to debug output")
   modern = flag.Bool("modern",true,"set true to use modern interpretation of ambiguous instructions, default true")
+  keyPress = flag.Bool("keyPress",false,"FX0A (wait for a key) finishes when a key is pressed, like CHIP-48 and SUPER-CHIP, rather than when it is released like the COSMAC VIP")
+  listing = flag.String("coverageListing","","if set, write a disassembly annotated with execution counts here on exit")
+  lcov = flag.String("lcov","","if set, write an lcov coverage tracefile here on exit")
+  scale = flag.Int("scale",10,"initial window size, in screen pixels per Chip8 pixel")
+  fullscreen = flag.Bool("fullscreen",false,"start in fullscreen, F11 toggles it")
+  fit = flag.Bool("fit",false,"scale the display to fill the window instead of by whole numbers")
+  palette = flag.String("palette","","colour palette: classic, amber, green, lcd, high-contrast, or hex colours like #000000,#FF8800; F2 cycles them and remembers the choice for this ROM")
+  persistence = flag.String("persistence","none","flicker reduction: none, decay (pixels fade out) or or (pixels stay lit for -persistenceFrames frames)")
+  decay = flag.Float64("decay",0.6,"with -persistence decay, the fraction of its brightness a pixel keeps each frame")
+  persistenceFrames = flag.Int("persistenceFrames",3,"with -persistence or, how many frames a pixel stays lit for")
+  record = flag.String("record","","record the screen to this .gif or .png (APNG), saved on exit; F10 starts and stops recording too")
+  recordAudio = flag.Bool("recordAudio",false,"also record the sound to a .wav with the same name as each recording, the same length as the video so the two can be muxed")
+  captureScale = flag.Int("captureScale",0,"size of a Chip8 pixel in F12 screenshots and recordings, default -scale")
+  frequency = flag.Float64("frequency",sound.DEFAULT_FREQUENCY,"pitch of the beep in Hz")
+  waveform = flag.String("waveform","square","shape of the beep: square, triangle, sine or sawtooth")
+  volume = flag.Float64("volume",sound.DEFAULT_VOLUME,"loudness of the beep from 0 to 1")
+  mute = flag.Bool("mute",false,"start with the sound off, F3 toggles it")
+  layout = flag.String("keys","","keypad layout: qwerty, azerty, numpad or cosmac (keys labelled 0-9 and A-F); default from the config, F4 rebinds keys one by one and remembers them for this ROM")
+  gamepadProfile = flag.String("gamepad","","gamepad profile: numeric (d-pad on 2/4/6/8) or wasd (d-pad on 5/7/8/9); default from the config")
+  deadZone = flag.Float64("deadZone",0,"how far a stick has to move, between 0 and 1, to press a key; default from the config or 0.25")
+  showKeypad = flag.Bool("keypad",false,"show the on-screen keypad, which can be clicked or touched; F5 toggles it")
+  platformName = flag.String("platform","","chip8 or xochip, which has 64K of memory for bigger ROMs; default from the ROM database or chip8")
+  libraryDir = flag.String("library","","directory of ROMs to pick from in the window instead of running -file; Esc goes back to the list. Can't be used with -coverageListing, -lcov or -record")
+  romDB = flag.String("romDB","","directory with a copy of the community CHIP-8 database (programs.json, sha1-hashes.json, platforms.json) to pick quirks, speed and palette by ROM; default the bundled one, none to skip it")
+  configFile = flag.String("config",config.DefaultPath(),"settings file, including per-ROM palettes")
+  symbols = flag.String("symbols","","symbol map (\"<address> <file> <line>\" per line) used to map lcov coverage to assembly source")
+}
+
+func writeCoverage(chip8 *cpu.Chip8, tracker *coverage.Tracker) {
+  // without a symbol map, lcov points at lines of the listing, so we need one
+  if *lcov != "" && *symbols == "" && *listing == "" {
+    *listing = *lcov + ".lst"
+  }
+  if *listing != "" {
+    f, err := os.Create(*listing)
+    if err != nil {
+      log.Fatal(err)
+    }
+    defer f.Close()
+    if err := tracker.WriteListing(f, chip8.Program(), cpu.PROGRAM_START); err != nil {
+      log.Fatal(err)
+    }
+  }
+  if *lcov != "" {
+    var symbolMap coverage.SymbolMap
+    if *symbols != "" {
+      var err error
+      if symbolMap, err = coverage.LoadSymbols(*symbols); err != nil {
+        log.Fatal(err)
+      }
+    }
+    f, err := os.Create(*lcov)
+    if err != nil {
+      log.Fatal(err)
+    }
+    defer f.Close()
+    if err := tracker.WriteLcov(f, chip8.Program(), cpu.PROGRAM_START, symbolMap, *listing); err != nil {
+      log.Fatal(err)
+    }
+  }
+}
+
+// the ROM database, see -romDB; nil with -romDB none
+func romDatabase() *romdb.Database {
+  if *romDB == "none" {
+    return nil
+  }
+  db, err := romdb.Bundled()
+  if *romDB != "" {
+    db, err = romdb.Load(*romDB)
+  }
+  if err != nil {
+    log.Fatal(err)
+  }
+  return db
+}
+
+// the database knows what known ROMs need, but flags given by hand win
+func romQuirks(rom library.ROM, explicit map[string]bool) cpu.Quirks {
+  quirks := cpu.QuirksFor(*modern)
+  if !explicit["modern"] {
+    quirks = rom.Quirks(quirks)
+  }
+  if !rom.Known || explicit["keyPress"] {
+    quirks.KeyPress = *keyPress
+  }
+  return quirks
+}
+
+// -platform wins over the database
+func romPlatform(rom library.ROM) (library.ROM, error) {
+  if *platformName != "" {
+    platform, err := cpu.ParsePlatform(*platformName)
+    if err != nil {
+      return rom, err
+    }
+    rom.Entry.Machine = platform
+  }
+  return rom, nil
+}
+
+func newChip8(rom library.ROM, explicit map[string]bool) (*cpu.Chip8, error) {
+  rom, err := romPlatform(rom)
+  if err != nil {
+    return nil, err
+  }
+  if rom.Known && !rom.Cartridge {
+    log.Printf("%s by %s, for %s", rom.Entry.Title, strings.Join(rom.Entry.Authors, ", "), rom.Entry.Platform)
+  }
+  if hints := rom.Entry.KeyHints(); hints != "" {
+    log.Printf("keys: %s", hints)
+  }
+  return rom.NewChip8(*debug, romQuirks(rom, explicit))
+}
+
+// -palette, or the one picked for the ROM, or the ROM's own colours, or the global one
+func romPalette(rom library.ROM, settings *config.Config) string {
+  if *palette != "" {
+    return *palette
+  }
+  if own, ok := settings.ROMs[rom.Key]; ok && own.Palette != "" {
+    return own.Palette
+  }
+  if rom.Entry.Palette != "" {
+    return rom.Entry.Palette
+  }
+  return settings.Palette
+}
+
+// the window for a ROM, remembering palette and key changes for it in settings
+func newGame(chip8 *cpu.Chip8, rom library.ROM, settings *config.Config) (*display.Game, error) {
+  romSettings := settings.ForROM(rom.Key)
+  bindings := *romSettings.Keys
+  if *layout != "" {
+    bindings.Layout, bindings.Keys = *layout, nil
+  }
+  if *gamepadProfile != "" {
+    bindings.GamepadProfile, bindings.Gamepad = *gamepadProfile, nil
+  }
+  if *deadZone > 0 {
+    bindings.DeadZone = *deadZone
+  }
+  keys, err := bindings.Keymap()
+  if err != nil {
+    return nil, err
+  }
+  gamepadMap, err := bindings.GamepadMap()
+  if err != nil {
+    return nil, err
+  }
+
+  persistenceMode, err := screen.ParsePersistenceMode(*persistence)
+  if err != nil {
+    return nil, err
+  }
+
+  beepWaveform, err := sound.ParseWaveform(*waveform)
+  if err != nil {
+    return nil, err
+  }
+
+  scaleMode := display.INTEGER
+  if *fit {
+    scaleMode = display.FIT
+  }
+  return display.NewGame(chip8, display.Options{
+    Scale: *scale,
+    Fullscreen: *fullscreen,
+    ScaleMode: scaleMode,
+    Name: rom.Path,
+    Title: rom.Entry.Title,
+    Palette: romPalette(rom, settings),
+    Persistence: persistenceMode,
+    Decay: *decay,
+    PersistenceFrames: *persistenceFrames,
+    CaptureScale: *captureScale,
+    Record: *record,
+    RecordAudio: *recordAudio,
+    Frequency: *frequency,
+    Waveform: beepWaveform,
+    Volume: *volume,
+    Muted: *mute,
+    Keymap: keys,
+    GamepadMap: gamepadMap,
+    DeadZone: bindings.GetDeadZone(),
+    ShowKeypad: *showKeypad,
+    OnKeymapChange: func(k keymap.Keymap) {
+      // keeps the ROM's gamepad settings
+      own := settings.ROM(rom.Key)
+      if own.Keys == nil {
+        own.Keys = &keymap.Bindings{}
+      }
+      diff := keymap.Diff(bindings.Layout, k)
+      own.Keys.Layout, own.Keys.Keys = diff.Layout, diff.Keys
+      if err := settings.Save(*configFile); err != nil {
+        log.Println(err)
+      }
+    },
+    OnPaletteChange: func(palette string) {
+      settings.ROM(rom.Key).Palette = palette
+      if err := settings.Save(*configFile); err != nil {
+        log.Println(err)
+      }
+    },
+  })
+}
+
+// -library: pick ROMs from a directory, and come back with Esc
+func runLibrary(db *romdb.Database, settings *config.Config, explicit map[string]bool) {
+  // these are about one ROM, and each ROM played would overwrite the last one's file
+  for _, name := range []string{"coverageListing", "lcov", "symbols", "record"} {
+    if explicit[name] {
+      log.Fatalf("-%s doesn't work with -library, it's for running one -file", name)
+    }
+  }
+  roms, err := library.Scan(*libraryDir, db)
+  if err != nil {
+    log.Fatal(err)
+  }
+  items := make([]display.LibraryItem, len(roms))
+  for index, rom := range roms {
+    if roms[index], err = romPlatform(rom); err != nil {
+      log.Fatal(err)
+    }
+    rom := roms[index]
+    items[index] = display.LibraryItem{
+      Name: rom.Name(),
+      Info: rom.Info(),
+      Palette: romPalette(rom, settings),
+      Preview: func() (*cpu.Chip8, error) {
+        return rom.NewChip8(false, romQuirks(rom, explicit))
+      },
+    }
+  }
+  launcher := display.NewLauncher(items, func(index int) (*display.Game, error) {
+    chip8, err := newChip8(roms[index], explicit)
+    if err != nil {
+      return nil, err
+    }
+    return newGame(chip8, roms[index], settings)
+  })
+  launcher.ConfigureWindow(*scale, *fullscreen)
+  if err := ebiten.RunGame(launcher); err != nil {
+    log.Fatal(err)
+  }
+  launcher.Close()
 }
 
 func main() {
   flag.Parse()
 
   fmt.Println("Starting up...")
-  chip8 := cpu.NewChip8(*debug, *modern)
-  chip8.LoadFile(*file)
+  explicit := map[string]bool{}
+  flag.Visit(func(f *flag.Flag) {
+    explicit[f.Name] = true
+  })
+  if explicit["deadZone"] {
+    if err := keymap.CheckDeadZone(*deadZone); err != nil {
+      log.Fatal(err)
+    }
+  }
+  db := romDatabase()
+  settings, err := config.Load(*configFile)
+  if err != nil {
+    log.Fatal(err)
+  }
+
+  if *libraryDir != "" {
+    runLibrary(db, settings, explicit)
+    return
+  }
+
+  rom, err := library.Load(*file, db)
+  if err != nil {
+    log.Fatal(err)
+  }
+  chip8, err := newChip8(rom, explicit)
+  if err != nil {
+    log.Fatal(err)
+  }
+
+  var tracker *coverage.Tracker
+  if *listing != "" || *lcov != "" {
+    tracker = chip8.EnableCoverage()
+  }
 
-  ebiten.SetWindowSize(640, 320)
-  ebiten.SetWindowTitle("Hello, World!")
-  game, _ := display.NewGame(chip8)
+  game, err := newGame(chip8, rom, settings)
+  if err != nil {
+    log.Fatal(err)
+  }
+  game.ConfigureWindow()
 
-  // infinite loop at chip8.clockSpeed
-  go chip8.Execute()
+  // infinite loop at chip8.clockSpeed, until the window closes
+  stop, done := make(chan struct{}), make(chan struct{})
+  go func() {
+    if err := chip8.ExecuteUntil(stop); err != nil {
+      log.Fatal(err)
+    }
+    close(done)
+  }()
 
   // display updates @ 60Hz via infinite loop in ebiten
   if err := ebiten.RunGame(game); err != nil {
     log.Fatal(err)
   }
+  // the core has to be stopped before its coverage counts are read
+  close(stop)
+  <-done
+  game.StopRecording()
+
+  if tracker != nil {
+    writeCoverage(chip8, tracker)
+  }
 }
@@ -0,0 +1,164 @@
+package main
+
+import (
+  "encoding/json"
+  "flag"
+  "fmt"
+  "log"
+  "os"
+
+  "jfeintzeig/chip8/internal/cpu"
+  "jfeintzeig/chip8/internal/screen"
+  "jfeintzeig/chip8/internal/script"
+  "jfeintzeig/chip8/internal/sound"
+)
+
+// exit codes, so CI can tell a crash from a wrong screen.
+// log.Fatal exits with EXIT_ERROR too.
+const (
+  EXIT_OK = 0
+  EXIT_ERROR = 1
+  EXIT_MISMATCH = 2
+)
+
+var (
+  file *string
+  frames *int
+  modern *bool
+  keyPress *bool
+  inputFile *string
+  pngFile *string
+  scale *int
+  palette *string
+  asciiFile *string
+  stateFile *string
+  expect *string
+  record *string
+  wav *string
+  platformName *string
+)
+
+func init() {
+  file = flag.String("file","data/ibm_logo.ch8","path to file to load: - for stdin, and a .zip or a file inside one like games.zip/pong.ch8")
+  platformName = flag.String("platform","chip8","chip8 or xochip, which has 64K of memory for bigger ROMs")
+  frames = flag.Int("frames",60,"number of 60Hz frames to run before dumping state")
+  modern = flag.Bool("modern",true,"set true to use modern interpretation of ambiguous instructions, default true")
+  keyPress = flag.Bool("keyPress",false,"FX0A (wait for a key) finishes when a key is pressed, like CHIP-48 and SUPER-CHIP, rather than when it is released like the COSMAC VIP")
+  inputFile = flag.String("input","","optional script of key presses, one \"<frame> <key> <down|up>\" per line")
+  pngFile = flag.String("png","","if set, write the final framebuffer as a PNG here")
+  scale = flag.Int("scale",10,"size of each Chip8 pixel in the PNG and recording")
+  palette = flag.String("palette","classic","colour palette for the PNG and recording: classic, amber, green, lcd, high-contrast, or hex colours like #000000,#FF8800")
+  asciiFile = flag.String("ascii","","if set, write the final framebuffer as ASCII art here, - for stdout")
+  stateFile = flag.String("state","","if set, write the final registers as JSON here, - for stdout")
+  record = flag.String("record","","if set, record every frame to this .gif or .png (APNG)")
+  wav = flag.String("wav","","if set, render the beep and any XO-CHIP audio patterns to this WAV file, one 60th of a second per frame, so it lines up with -record")
+  expect = flag.String("expect","","if set, exit with status 2 unless the sha1 of the final framebuffer matches")
+}
+
+func create(path string) *os.File {
+  if path == "-" {
+    return os.Stdout
+  }
+  f, err := os.Create(path)
+  if err != nil {
+    log.Fatal(err)
+  }
+  return f
+}
+
+func main() {
+  flag.Parse()
+
+  pngPalette, err := screen.ParsePalette(*palette)
+  if err != nil {
+    log.Fatal(err)
+  }
+
+  quirks := cpu.QuirksFor(*modern)
+  quirks.KeyPress = *keyPress
+  chip8 := cpu.NewChip8(false, quirks)
+  platform, err := cpu.ParsePlatform(*platformName)
+  if err != nil {
+    log.Fatal(err)
+  }
+  chip8.SetPlatform(platform)
+  if err := chip8.LoadFile(*file); err != nil {
+    log.Fatal(err)
+  }
+
+  input := script.Script{}
+  if *inputFile != "" {
+    var err error
+    if input, err = script.Load(*inputFile); err != nil {
+      log.Fatal(err)
+    }
+  }
+
+  frontEnd := cpu.FrontEnd{Input: input.Player()}
+  var recorder *screen.Recorder
+  if *record != "" {
+    recorder = screen.NewRecorder(*scale, pngPalette)
+    frontEnd.Video = recorder
+  }
+  var renderer *sound.Renderer
+  if *wav != "" {
+    renderer = sound.NewRenderer(sound.NewTone(sound.DEFAULT_FREQUENCY, sound.SQUARE, sound.DEFAULT_VOLUME))
+    frontEnd.Audio = renderer
+  }
+  chip8.Attach(frontEnd)
+
+  // still dump whatever state we got to if the ROM crashes, it helps with debugging
+  var runErr error
+  for frame := 0; frame < *frames && runErr == nil; frame++ {
+    runErr = chip8.RunFrame()
+  }
+  if recorder != nil {
+    if err := recorder.Save(*record); err != nil {
+      log.Fatal(err)
+    }
+  }
+  if renderer != nil {
+    if err := renderer.Save(*wav); err != nil {
+      log.Fatal(err)
+    }
+  }
+
+  width, height := chip8.Resolution()
+  frame := screen.NewFrame(chip8.Display[:], width, height)
+
+  if *pngFile != "" {
+    f := create(*pngFile)
+    if err := frame.WritePNGWithPalette(f, *scale, pngPalette); err != nil {
+      log.Fatal(err)
+    }
+    f.Close()
+  }
+  if *asciiFile != "" {
+    f := create(*asciiFile)
+    fmt.Fprint(f, frame.ASCII())
+    if f != os.Stdout {
+      f.Close()
+    }
+  }
+  if *stateFile != "" {
+    f := create(*stateFile)
+    encoder := json.NewEncoder(f)
+    encoder.SetIndent("", "  ")
+    if err := encoder.Encode(chip8.State()); err != nil {
+      log.Fatal(err)
+    }
+    if f != os.Stdout {
+      f.Close()
+    }
+  }
+
+  if runErr != nil {
+    fmt.Fprintln(os.Stderr, runErr)
+    os.Exit(EXIT_ERROR)
+  }
+  if *expect != "" && frame.Hash() != *expect {
+    fmt.Fprintf(os.Stderr, "framebuffer hash %s, expected %s\n", frame.Hash(), *expect)
+    os.Exit(EXIT_MISMATCH)
+  }
+  os.Exit(EXIT_OK)
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+  "bufio"
+  "flag"
+  "fmt"
+  "log"
+  "os"
+  "os/exec"
+  "strings"
+  "time"
+
+  "jfeintzeig/chip8/internal/cpu"
+  "jfeintzeig/chip8/internal/screen"
+)
+
+var (
+  file *string
+  modern *bool
+  keyPress *bool
+  quarter *bool
+  keyTimeout *time.Duration
+  noDebugger *bool
+  platformName *string
+)
+
+func init() {
+  file = flag.String("file","data/ibm_logo.ch8","path to file to load: a .zip or a file inside one like games.zip/pong.ch8")
+  platformName = flag.String("platform","chip8","chip8 or xochip, which has 64K of memory for bigger ROMs")
+  modern = flag.Bool("modern",true,"set true to use modern interpretation of ambiguous instructions, default true")
+  keyPress = flag.Bool("keyPress",false,"FX0A (wait for a key) finishes when a key is pressed, like CHIP-48 and SUPER-CHIP, rather than when it is released like the COSMAC VIP")
+  quarter = flag.Bool("quarter",false,"draw 2x2 pixels per character instead of 1x2, for small terminals or hi-res ROMs")
+  keyTimeout = flag.Duration("keyTimeout",200*time.Millisecond,"terminals don't report key releases, so a key counts as released this long after its last press or repeat")
+  noDebugger = flag.Bool("noDebugger",false,"hide the register pane")
+}
+
+// host key for each Chip8 key, the same layout as the window
+var keyboard = [16]byte{'x', '1', '2', '3', 'q', 'w', 'e', 'a', 's', 'd', 'z', 'c', '4', 'r', 'f', 'v'}
+
+const (
+  CTRL_C = 3
+  ESCAPE = 27
+)
+
+// Arrows and function keys send escape sequences, ESC [ ... or ESC O x, so
+// ESC only quits when nothing follows it before the next frame.
+type escapeState int
+
+const (
+  NO_ESCAPE escapeState = iota
+  // just read an ESC
+  ESCAPE_STARTED
+  // in a sequence, which ends with a byte from @ to ~
+  IN_SEQUENCE
+)
+
+// raw mode, so we get key presses as they happen without echo; returns a
+// function that puts the terminal back how it was
+func rawTerminal() func() {
+  stty := func(args ...string) string {
+    cmd := exec.Command("stty", args...)
+    cmd.Stdin = os.Stdin
+    out, err := cmd.Output()
+    if err != nil {
+      log.Fatalf("stty %s: %v (is stdin a terminal?)", strings.Join(args, " "), err)
+    }
+    return strings.TrimSpace(string(out))
+  }
+  saved := stty("-g")
+  stty("raw", "-echo")
+  // hide the cursor and clear the screen
+  fmt.Print("\x1b[?25l\x1b[2J")
+  return func() {
+    stty(saved)
+    fmt.Print("\x1b[?25h\x1b[0m\r\n")
+  }
+}
+
+// sends every byte typed to the returned channel
+func readKeys() <-chan byte {
+  keys := make(chan byte, 64)
+  go func() {
+    reader := bufio.NewReader(os.Stdin)
+    for {
+      b, err := reader.ReadByte()
+      if err != nil {
+        close(keys)
+        return
+      }
+      keys <- b
+    }
+  }()
+  return keys
+}
+
+// the cpu.FrontEnd for a terminal
+type terminal struct {
+  // when each Chip8 key was last seen, zero if it's up
+  lastSeen [16]time.Time
+  now time.Time
+  frame screen.Frame
+  soundOn bool
+}
+
+func (t *terminal) PollKeys(keys *[16]bool) {
+  for key := range t.lastSeen {
+    keys[key] = !t.lastSeen[key].IsZero() && t.now.Sub(t.lastSeen[key]) < *keyTimeout
+  }
+}
+
+func (t *terminal) DrawFrame(pixels []uint8, width int, height int) {
+  t.frame = screen.NewFrame(pixels, width, height)
+}
+
+// the terminal bell is all we've got, so ring it when the sound starts
+func (t *terminal) SetTone(on bool) {
+  if on && !t.soundOn {
+    os.Stdout.WriteString("\a")
+  }
+  t.soundOn = on
+}
+
+// the register pane shown next to the screen
+func debuggerPane(c8 *cpu.Chip8, paused bool, runErr error) []string {
+  state := c8.State()
+  next := c8.NextInstruction()
+  status := "running   p pause"
+  if paused {
+    status = "PAUSED    p resume, n step"
+  }
+  lines := []string{
+    status,
+    fmt.Sprintf("PC %03X  %s", state.PC, next.ToString()),
+    fmt.Sprintf("I  %03X", state.I),
+    fmt.Sprintf("DT %02X  ST %02X", state.DelayTimer, state.SoundTimer),
+  }
+  for row := 0; row < 4; row++ {
+    var sb strings.Builder
+    for column := 0; column < 4; column++ {
+      register := row*4 + column
+      fmt.Fprintf(&sb, "V%X %02X  ", register, state.V[register])
+    }
+    lines = append(lines, sb.String())
+  }
+  lines = append(lines, fmt.Sprintf("stack %X", state.Stack))
+  if runErr != nil {
+    lines = append(lines, "error: " + runErr.Error())
+  }
+  lines = append(lines, "esc quit")
+  return lines
+}
+
+func (t *terminal) draw(c8 *cpu.Chip8, paused bool, runErr error) {
+  frame := t.frame
+  var lines []string
+  if *quarter || frame.Width > 64 {
+    lines = frame.QuarterBlocks()
+  } else {
+    lines = frame.HalfBlocks()
+  }
+
+  pane := []string{}
+  if !*noDebugger {
+    pane = debuggerPane(c8, paused, runErr)
+  }
+
+  var sb strings.Builder
+  // back to the top left and redraw over the last frame
+  sb.WriteString("\x1b[H")
+  for row := 0; row < len(lines) || row < len(pane); row++ {
+    if row < len(lines) {
+      sb.WriteString("│" + lines[row] + "│")
+    } else {
+      sb.WriteString(strings.Repeat(" ", len([]rune(lines[0])) + 2))
+    }
+    if row < len(pane) {
+      sb.WriteString("  " + pane[row])
+    }
+    // clear whatever was left over at the end of the line
+    sb.WriteString("\x1b[K\r\n")
+  }
+  os.Stdout.WriteString(sb.String())
+}
+
+func main() {
+  flag.Parse()
+
+  quirks := cpu.QuirksFor(*modern)
+  quirks.KeyPress = *keyPress
+  chip8 := cpu.NewChip8(false, quirks)
+  if *file == "-" {
+    // stdin is the keyboard
+    log.Fatal("can't read the ROM from stdin, the keypad needs it")
+  }
+  platform, err := cpu.ParsePlatform(*platformName)
+  if err != nil {
+    log.Fatal(err)
+  }
+  chip8.SetPlatform(platform)
+  if err := chip8.LoadFile(*file); err != nil {
+    log.Fatal(err)
+  }
+
+  restore := rawTerminal()
+  defer restore()
+
+  term := &terminal{}
+  chip8.Attach(cpu.FrontEnd{Video: term, Audio: term, Input: term})
+  chip8.Refresh()
+
+  keys := readKeys()
+  paused := false
+  escape := NO_ESCAPE
+  var runErr error
+
+  ticker := time.NewTicker(time.Second / time.Duration(cpu.DELAY_SOUND_TIMER_UPDATE))
+  defer ticker.Stop()
+  for now := range ticker.C {
+    term.now = now
+    step := false
+    // an ESC from the last frame that nothing has followed yet
+    loneEscape := escape == ESCAPE_STARTED
+  ReadKeys:
+    for {
+      select {
+      case b, ok := <-keys:
+        if !ok || b == CTRL_C {
+          return
+        }
+        switch escape {
+        case ESCAPE_STARTED:
+          loneEscape, escape = false, NO_ESCAPE
+          if b == '[' || b == 'O' {
+            escape = IN_SEQUENCE
+            continue
+          }
+          // anything else, like Alt and a key, is just the key
+        case IN_SEQUENCE:
+          if b >= '@' && b <= '~' {
+            escape = NO_ESCAPE
+          }
+          continue
+        }
+        if b == ESCAPE {
+          escape = ESCAPE_STARTED
+          continue
+        }
+        switch b {
+        case 'p':
+          paused = !paused
+        case 'n':
+          step = true
+        }
+        for key, host := range keyboard {
+          if b == host {
+            term.lastSeen[key] = now
+          }
+        }
+      default:
+        break ReadKeys
+      }
+    }
+    if loneEscape {
+      return
+    }
+
+    if runErr == nil {
+      if !paused {
+        runErr = chip8.RunFrame()
+      } else if step {
+        runErr = chip8.Step()
+        chip8.Refresh()
+      }
+    }
+
+    term.draw(chip8, paused || runErr != nil, runErr)
+  }
+}
@@ -0,0 +1,114 @@
+package config
+
+import (
+  "crypto/sha1"
+  "encoding/hex"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "os"
+  "path/filepath"
+
+  "jfeintzeig/chip8/internal/keymap"
+)
+
+// settings for one ROM, overriding the global ones where set
+type ROMConfig struct {
+  // a name from screen.PALETTES or hex colours, see screen.ParsePalette
+  Palette string `json:"palette,omitempty"`
+  // layered over the global bindings, see keymap.Merge
+  Keys *keymap.Bindings `json:"keys,omitempty"`
+}
+
+// everything the app remembers between runs, saved as JSON, e.g.
+//     {"palette": "amber", "keys": {"layout": "azerty"},
+//      "roms": {"<sha1 of rom>": {"palette": "lcd", "keys": {"keys": {"5": ["ArrowUp"]}}}}}
+type Config struct {
+  Palette string `json:"palette,omitempty"`
+  Keys *keymap.Bindings `json:"keys,omitempty"`
+  // keyed by ROMKey, so settings follow a ROM when it's renamed or moved
+  ROMs map[string]*ROMConfig `json:"roms,omitempty"`
+}
+
+// <user config dir>/chip8/config.json, e.g. ~/.config/chip8/config.json on linux
+func DefaultPath() string {
+  dir, err := os.UserConfigDir()
+  if err != nil {
+    return "chip8.json"
+  }
+  return filepath.Join(dir, "chip8", "config.json")
+}
+
+// sha1 of the ROM, in hex
+func ROMKey(program []byte) string {
+  sum := sha1.Sum(program)
+  return hex.EncodeToString(sum[:])
+}
+
+// a missing file isn't an error, it's just an empty config
+func Load(path string) (*Config, error) {
+  c := &Config{ROMs: map[string]*ROMConfig{}}
+  data, err := os.ReadFile(path)
+  if errors.Is(err, os.ErrNotExist) {
+    return c, nil
+  }
+  if err != nil {
+    return nil, err
+  }
+  if err := json.Unmarshal(data, c); err != nil {
+    return nil, err
+  }
+  if c.ROMs == nil {
+    c.ROMs = map[string]*ROMConfig{}
+  }
+  // 0 means the default, anything else has to work
+  keys := []*keymap.Bindings{c.Keys}
+  for _, rom := range c.ROMs {
+    if rom != nil {
+      keys = append(keys, rom.Keys)
+    }
+  }
+  for _, bindings := range keys {
+    if bindings == nil || bindings.DeadZone == 0 {
+      continue
+    }
+    if err := keymap.CheckDeadZone(bindings.DeadZone); err != nil {
+      return nil, fmt.Errorf("%s: %w", path, err)
+    }
+  }
+  return c, nil
+}
+
+func (c *Config) Save(path string) error {
+  data, err := json.MarshalIndent(c, "", "  ")
+  if err != nil {
+    return err
+  }
+  if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+    return err
+  }
+  return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// the settings for a ROM, with the global ones filled in where it has none
+func (c *Config) ForROM(key string) ROMConfig {
+  merged := ROMConfig{Palette: c.Palette}
+  var romKeys *keymap.Bindings
+  if rom, ok := c.ROMs[key]; ok {
+    if rom.Palette != "" {
+      merged.Palette = rom.Palette
+    }
+    romKeys = rom.Keys
+  }
+  keys := keymap.Merge(c.Keys, romKeys)
+  merged.Keys = &keys
+  return merged
+}
+
+// the ROM's own settings, created if it doesn't have any yet, for changing them
+func (c *Config) ROM(key string) *ROMConfig {
+  if _, ok := c.ROMs[key]; !ok {
+    c.ROMs[key] = &ROMConfig{}
+  }
+  return c.ROMs[key]
+}
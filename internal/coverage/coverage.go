@@ -0,0 +1,229 @@
+package coverage
+
+import (
+  "bufio"
+  "fmt"
+  "io"
+  "os"
+  "sort"
+  "strconv"
+  "strings"
+
+  "jfeintzeig/chip8/internal/utils"
+)
+
+// big enough for any uint16 address, so we don't care how much memory the machine has
+const ADDRESS_SPACE = 0x10000
+
+// Tracker counts how often each address was executed and, for the skip
+// instructions, how often the skip was taken vs. not taken.
+type Tracker struct {
+  Hits [ADDRESS_SPACE]uint64
+  Taken [ADDRESS_SPACE]uint64
+  NotTaken [ADDRESS_SPACE]uint64
+}
+
+func NewTracker() *Tracker {
+  return &Tracker{}
+}
+
+// 3XNN, 4XNN, 5XY0, 9XY0, EX9E and EXA1 are the only conditional instructions
+func IsSkip(inst *utils.Instruction) bool {
+  switch inst.A {
+  case 0x3, 0x4, 0x5, 0x9:
+    return true
+  case 0xE:
+    return inst.NN == 0x9E || inst.NN == 0xA1
+  }
+  return false
+}
+
+// called by the cpu after executing the instruction at address; nextPC is the
+// program counter afterwards, so a skip was taken if it jumped over the next instruction
+func (t *Tracker) Record(address uint16, inst *utils.Instruction, nextPC uint16) {
+  t.Hits[address]++
+  if IsSkip(inst) {
+    if nextPC == address + 4 {
+      t.Taken[address]++
+    } else {
+      t.NotTaken[address]++
+    }
+  }
+}
+
+// one row of the listing: an instruction (or a stray data byte) in the ROM
+type line struct {
+  address uint16
+  size uint16
+  inst utils.Instruction
+}
+
+// walks the ROM two bytes at a time, but falls back to a single byte when
+// execution started at an odd address so we stay aligned with what actually ran
+func (t *Tracker) lines(rom []byte, start uint16) []line {
+  lines := []line{}
+  for offset := 0; offset < len(rom); {
+    address := start + uint16(offset)
+    if offset + 1 >= len(rom) || (t.Hits[address] == 0 && t.Hits[address+1] > 0) {
+      lines = append(lines, line{address, 1, utils.InstructionFromBytecode(uint16(rom[offset]) << 8)})
+      offset += 1
+      continue
+    }
+    codedInstruction := (uint16(rom[offset]) << 8) | uint16(rom[offset+1])
+    lines = append(lines, line{address, 2, utils.InstructionFromBytecode(codedInstruction)})
+    offset += 2
+  }
+  return lines
+}
+
+// writes a disassembly of the ROM with execution counts in the left margin,
+// like gcov: "#####" marks instructions that never ran
+func (t *Tracker) WriteListing(w io.Writer, rom []byte, start uint16) error {
+  for _, l := range t.lines(rom, start) {
+    count := "#####"
+    if t.Hits[l.address] > 0 {
+      count = strconv.FormatUint(t.Hits[l.address], 10)
+    }
+    text := l.inst.ToString()
+    if l.size == 1 {
+      text = fmt.Sprintf("0x%02X # data", l.inst.Full >> 8)
+    }
+    branches := ""
+    if l.size == 2 && IsSkip(&l.inst) {
+      branches = fmt.Sprintf("  [taken %d, not taken %d]", t.Taken[l.address], t.NotTaken[l.address])
+    }
+    if _, err := fmt.Fprintf(w, "%9s | 0x%03X: %s%s\n", count, l.address, text, branches); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+type SourceLine struct {
+  File string
+  Line int
+}
+
+// maps a memory address to the assembly source line it was assembled from
+type SymbolMap map[uint16]SourceLine
+
+// reads a symbol map, one "<hex address> <file> <line>" entry per line, e.g.
+//     0x200 game.8o 12
+// blank lines and lines starting with # are ignored
+func LoadSymbols(path string) (SymbolMap, error) {
+  file, err := os.Open(path)
+  if err != nil {
+    return nil, err
+  }
+  defer file.Close()
+
+  symbols := SymbolMap{}
+  scanner := bufio.NewScanner(file)
+  lineNumber := 0
+  for scanner.Scan() {
+    lineNumber++
+    text := strings.TrimSpace(scanner.Text())
+    if text == "" || strings.HasPrefix(text, "#") {
+      continue
+    }
+    fields := strings.Fields(text)
+    if len(fields) != 3 {
+      return nil, fmt.Errorf("%s:%d: expected \"<address> <file> <line>\"", path, lineNumber)
+    }
+    address, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(fields[0]), "0x"), 16, 16)
+    if err != nil {
+      return nil, fmt.Errorf("%s:%d: bad address %q", path, lineNumber, fields[0])
+    }
+    sourceLine, err := strconv.Atoi(fields[2])
+    if err != nil {
+      return nil, fmt.Errorf("%s:%d: bad line number %q", path, lineNumber, fields[2])
+    }
+    symbols[uint16(address)] = SourceLine{fields[1], sourceLine}
+  }
+  return symbols, scanner.Err()
+}
+
+type lcovLine struct {
+  hits uint64
+  branch bool
+  taken uint64
+  notTaken uint64
+}
+
+// writes an lcov tracefile. With a symbol map, counts are attributed to the
+// assembly source lines; without one, they're attributed to the lines of the
+// annotated listing, which is written to listingPath by WriteListing.
+func (t *Tracker) WriteLcov(w io.Writer, rom []byte, start uint16, symbols SymbolMap, listingPath string) error {
+  files := map[string]map[int]*lcovLine{}
+  for index, l := range t.lines(rom, start) {
+    source := SourceLine{listingPath, index + 1}
+    if symbols != nil {
+      var ok bool
+      if source, ok = symbols[l.address]; !ok {
+        // data or padding that the assembler didn't emit a symbol for
+        continue
+      }
+    }
+    if files[source.File] == nil {
+      files[source.File] = map[int]*lcovLine{}
+    }
+    // several addresses can come from the same source line (e.g. macros), so sum them
+    entry, ok := files[source.File][source.Line]
+    if !ok {
+      entry = &lcovLine{}
+      files[source.File][source.Line] = entry
+    }
+    entry.hits += t.Hits[l.address]
+    if l.size == 2 && IsSkip(&l.inst) {
+      entry.branch = true
+      entry.taken += t.Taken[l.address]
+      entry.notTaken += t.NotTaken[l.address]
+    }
+  }
+
+  fileNames := []string{}
+  for name := range files {
+    fileNames = append(fileNames, name)
+  }
+  sort.Strings(fileNames)
+
+  bw := bufio.NewWriter(w)
+  for _, name := range fileNames {
+    lineNumbers := []int{}
+    for number := range files[name] {
+      lineNumbers = append(lineNumbers, number)
+    }
+    sort.Ints(lineNumbers)
+
+    fmt.Fprintf(bw, "TN:\nSF:%s\n", name)
+    linesHit, branchesFound, branchesHit := 0, 0, 0
+    for _, number := range lineNumbers {
+      entry := files[name][number]
+      if !entry.branch {
+        continue
+      }
+      for branch, count := range []uint64{entry.taken, entry.notTaken} {
+        if entry.hits == 0 {
+          // lcov wants "-" for branches on lines that never ran
+          fmt.Fprintf(bw, "BRDA:%d,0,%d,-\n", number, branch)
+        } else {
+          fmt.Fprintf(bw, "BRDA:%d,0,%d,%d\n", number, branch, count)
+        }
+        branchesFound++
+        if count > 0 {
+          branchesHit++
+        }
+      }
+    }
+    fmt.Fprintf(bw, "BRF:%d\nBRH:%d\n", branchesFound, branchesHit)
+    for _, number := range lineNumbers {
+      entry := files[name][number]
+      fmt.Fprintf(bw, "DA:%d,%d\n", number, entry.hits)
+      if entry.hits > 0 {
+        linesHit++
+      }
+    }
+    fmt.Fprintf(bw, "LF:%d\nLH:%d\nend_of_record\n", len(lineNumbers), linesHit)
+  }
+  return bw.Flush()
+}
@@ -0,0 +1,114 @@
+package coverage_test
+
+import (
+  "bytes"
+  "testing"
+
+  "jfeintzeig/chip8/internal/coverage"
+  "jfeintzeig/chip8/internal/cpu"
+)
+
+// counts V0 down from 3, then loops on the spot; the last instruction never
+// runs and the odd byte at the end is data
+var program = []byte{
+  0x60, 0x03, // 200: V0 = 3
+  0x70, 0xFF, // 202: V0 -= 1
+  0x30, 0x00, // 204: skip if V0 == 0
+  0x12, 0x02, // 206: jump 202
+  0x12, 0x08, // 208: jump 208
+  0x00, 0xE0, // 20A: clear
+  0xAB,
+}
+
+// runs the program for 9 instructions to reach 208, then 5 more there
+func run(t *testing.T) *coverage.Tracker {
+  chip8 := cpu.NewChip8(false, cpu.MODERN_QUIRKS)
+  if err := chip8.LoadBytes(program); err != nil {
+    t.Fatal(err)
+  }
+  tracker := chip8.EnableCoverage()
+  for step := 0; step < 14; step++ {
+    if err := chip8.Step(); err != nil {
+      t.Fatal(err)
+    }
+  }
+  return tracker
+}
+
+func TestWriteListing(t *testing.T) {
+  var got bytes.Buffer
+  if err := run(t).WriteListing(&got, program, cpu.PROGRAM_START); err != nil {
+    t.Fatal(err)
+  }
+  want := `        1 | 0x200: set V0 03 # 6003
+        3 | 0x202: add V0 FF # 70FF
+        3 | 0x204: skipe V0 00 # 3000  [taken 1, not taken 2]
+        2 | 0x206: jump 0202 # 1202
+        5 | 0x208: jump 0208 # 1208
+    ##### | 0x20A: clear # 00E0
+    ##### | 0x20C: 0xAB # data
+`
+  if got.String() != want {
+    t.Errorf("got listing:\n%s\nwant:\n%s", got.String(), want)
+  }
+}
+
+func TestWriteLcov(t *testing.T) {
+  tracker := run(t)
+
+  // without symbols, the lines are the listing's
+  var got bytes.Buffer
+  if err := tracker.WriteLcov(&got, program, cpu.PROGRAM_START, nil, "game.lst"); err != nil {
+    t.Fatal(err)
+  }
+  want := `TN:
+SF:game.lst
+BRDA:3,0,0,1
+BRDA:3,0,1,2
+BRF:2
+BRH:2
+DA:1,1
+DA:2,3
+DA:3,3
+DA:4,2
+DA:5,5
+DA:6,0
+DA:7,0
+LF:7
+LH:5
+end_of_record
+`
+  if got.String() != want {
+    t.Errorf("got tracefile:\n%s\nwant:\n%s", got.String(), want)
+  }
+
+  // with them, counts from one source line add up and unmapped addresses are left out
+  symbols := coverage.SymbolMap{
+    0x200: {"game.8o", 1},
+    0x202: {"game.8o", 2},
+    0x204: {"game.8o", 2},
+    0x206: {"game.8o", 3},
+    0x208: {"game.8o", 5},
+  }
+  got.Reset()
+  if err := tracker.WriteLcov(&got, program, cpu.PROGRAM_START, symbols, ""); err != nil {
+    t.Fatal(err)
+  }
+  want = `TN:
+SF:game.8o
+BRDA:2,0,0,1
+BRDA:2,0,1,2
+BRF:2
+BRH:2
+DA:1,1
+DA:2,6
+DA:3,2
+DA:5,5
+LF:4
+LH:4
+end_of_record
+`
+  if got.String() != want {
+    t.Errorf("got tracefile:\n%s\nwant:\n%s", got.String(), want)
+  }
+}
@@ -4,21 +4,26 @@ import (
   "bufio"
   "encoding/binary"
   "encoding/hex"
-  "errors"
   "fmt"
-  "io"
   "log"
   "os"
   "time"
 
+  "jfeintzeig/chip8/internal/coverage"
   "jfeintzeig/chip8/internal/utils"
 )
 
 const PROGRAM_START uint16 = 0x200
-const MAX_PROGRAM_ADDRESS uint16 = 0xE8F
 const FONT_START uint16 = 0x050
+const MEMORY_SIZE = 4096
 const CLOCK_SPEED uint16 = 500
 const DELAY_SOUND_TIMER_UPDATE uint16 = 60
+const DISPLAY_WIDTH = 64
+const DISPLAY_HEIGHT = 32
+// XO-CHIP's 1 bit audio pattern, 128 samples long
+const AUDIO_PATTERN_SIZE = 16
+// the pitch the pattern plays at until FX3A changes it, 4000 samples per second
+const DEFAULT_PITCH uint8 = 64
 
 type DebugState int
 
@@ -37,7 +42,17 @@ func NewKeypress() keypress {
   return keypress{false,false}
 }
 
-// TODO: i had to export Chip8 to use it in Display.go...another way?
+// FX0A puts the machine in this state until a key has been pressed and
+// released, so the wait spans frames and the timers keep running
+type keyWait struct {
+  waiting bool
+  // where the key goes once it's done
+  register uint8
+  // the key that was pressed, -1 until one is
+  key int8
+}
+
+// front ends talk to Chip8 through the interfaces in frontend.go
 type Chip8 struct {
   // program counter
   pc uint16
@@ -46,18 +61,19 @@ type Chip8 struct {
   delayTimer uint8
   soundTimer uint8
   // just an array, but the UI framework will use this to draw real pixels.
-  Display [32*64]uint8
+  Display [DISPLAY_HEIGHT*DISPLAY_WIDTH]uint8
   stack utils.Stack
   variableRegister [16]uint8
-  memory [4096]byte
+  // only the first Platform.MemorySize bytes are used
+  memory [XO_CHIP_MEMORY_SIZE]byte
   // maps the first nibble of an instruction to the actual execution logic
-  instructionMap map[uint8]func(*utils.Instruction)
+  instructionMap map[uint8]func(*utils.Instruction) error
   // just an array, but the UI framework will modify this
   Keyboard *[16]keypress
   clockSpeed uint16
   // some instructions have slightly different implementations depending on version/spec
   // this allows us to flip between them
-  modern bool
+  quirks Quirks
   // TODO: refactor debugger into separate struct in same package
   // could maybe have a debugger struct w/methods that interfaces with Chip8...if part
   // of package it can see private vars...?
@@ -65,60 +81,139 @@ type Chip8 struct {
   debug bool
   debugState DebugState
   debugBreakpoint uint16
+  // nil unless EnableCoverage was called
+  coverage *coverage.Tracker
+  // number of bytes loaded by LoadBytes, so we know where the program ends
+  programSize uint16
+  // see Attach
+  frontEnd FrontEnd
+  // XO-CHIP audio, set by F002 and FX3A; until a pattern is loaded the
+  // front end plays its own beep
+  audioPattern [AUDIO_PATTERN_SIZE]byte
+  hasAudioPattern bool
+  pitch uint8
+  // see waitForKey
+  keyWait keyWait
+  // frames run so far
+  frame uint64
+  // key events from an EventSource not applied yet, see pollInput
+  pendingKeys []KeyEvent
+  // see SetPlatform
+  platform Platform
 }
 
 func (c8 *Chip8) GetSoundTimer() uint8 {
   return c8.soundTimer
 }
 
+// width and height of the display in Chip8 pixels
+func (c8 *Chip8) Resolution() (int, int) {
+  return DISPLAY_WIDTH, DISPLAY_HEIGHT
+}
+
+// the number of frames RunFrame has run
+func (c8 *Chip8) Frame() uint64 {
+  return c8.frame
+}
+
+// instructions per second
+func (c8 *Chip8) ClockSpeed() uint16 {
+  return c8.clockSpeed
+}
+
+// sets the instructions per second, e.g. from the ROM database; call it
+// before Execute
+func (c8 *Chip8) SetClockSpeed(speed uint16) {
+  c8.clockSpeed = speed
+}
+
+// switches interpretation of the ambiguous instructions, e.g. once the ROM
+// is loaded and known to need a particular one
+func (c8 *Chip8) SetQuirks(quirks Quirks) {
+  c8.quirks = quirks
+}
+
+// presses or releases one of the 16 keys, for front ends that don't poll
+// a keyboard every tick. Releasing a pressed key sets JustReleased until the next call.
+func (c8 *Chip8) SetKey(key uint8, pressed bool) {
+  c8.Keyboard[key].JustReleased = c8.Keyboard[key].Pressed && !pressed
+  c8.Keyboard[key].Pressed = pressed
+}
+
+// a copy of the registers, for dumping or inspecting from outside the package
+type State struct {
+  PC uint16 `json:"pc"`
+  I uint16 `json:"i"`
+  V [16]uint8 `json:"v"`
+  Stack []uint16 `json:"stack"`
+  DelayTimer uint8 `json:"delayTimer"`
+  SoundTimer uint8 `json:"soundTimer"`
+}
+
+// the whole address space, writable, for tests and tools that poke at memory
+func (c8 *Chip8) Memory() []byte {
+  return c8.memory[:c8.platform.MemorySize()]
+}
+
+func (c8 *Chip8) State() State {
+  return State{
+    PC: c8.pc,
+    I: c8.i,
+    V: c8.variableRegister,
+    Stack: append([]uint16{}, c8.stack...),
+    DelayTimer: c8.delayTimer,
+    SoundTimer: c8.soundTimer,
+  }
+}
+
+// starts recording which instructions are executed, see internal/coverage
+func (c8 *Chip8) EnableCoverage() *coverage.Tracker {
+  c8.coverage = coverage.NewTracker()
+  return c8.coverage
+}
+
+// the bytes of the loaded program, as they are now in memory
+func (c8 *Chip8) Program() []byte {
+  return c8.memory[PROGRAM_START:PROGRAM_START+c8.programSize]
+}
+
 func (c8 *Chip8) incrementPC() {
   c8.pc += 2
 }
 
-func (c8 *Chip8) LoadFile(filePath string) {
-  file, err := os.Open(filePath)
-  if err != nil {
-    log.Fatal("can't find file")
-  }
-  br := bufio.NewReader(file)
-  i := uint16(0)
-  for {
-    b, err := br.ReadByte()
-    if err != nil && !errors.Is(err, io.EOF) {
-        fmt.Println(err)
-        break
-    }
-    c8.memory[PROGRAM_START + i] = b
-    if err != nil {
-        // end of file
-        break
-    }
-    if i > MAX_PROGRAM_ADDRESS {
-      log.Fatalf("Programs can only write between %x and %x in memory, loading this one overflowed",PROGRAM_START,MAX_PROGRAM_ADDRESS)
-    }
-    i++
-  }
+// addresses past the end of memory wrap around to the start, like the
+// 12 bit address bus on the original hardware; XO-CHIP has all 16 bits
+func (c8 *Chip8) wrap(address uint16) uint16 {
+  return uint16(int(address) % c8.platform.MemorySize())
 }
 
-// TODO: error handling that we don't outstep memory
 func (c8 *Chip8) fetchAndDecode() utils.Instruction {
-  twoBytes := c8.memory[c8.pc:c8.pc+2]
-  codedInstruction := (uint16(twoBytes[0]) << 8) | uint16(twoBytes[1])
+  instruction := c8.NextInstruction()
   c8.incrementPC()
-  return utils.InstructionFromBytecode(codedInstruction)
+  return instruction
 }
 
-func (c8 *Chip8) executeInstruction(instruction *utils.Instruction) {
-  if instructionFunc, ok := c8.instructionMap[instruction.A]; ok {
-    instructionFunc(instruction)
-  } else {
-    log.Fatalf("no instruction for %x, first nibble %x", instruction.Full, instruction.A)
+func (c8 *Chip8) executeInstruction(instruction *utils.Instruction) error {
+  // pc was already incremented by fetchAndDecode
+  address := c8.pc - 2
+  instructionFunc, ok := c8.instructionMap[instruction.A]
+  if !ok {
+    return fmt.Errorf("no instruction for %x at %03X, first nibble %x", instruction.Full, address, instruction.A)
   }
+  if err := instructionFunc(instruction); err != nil {
+    return fmt.Errorf("%03X: %w", address, err)
+  }
+  if c8.coverage != nil {
+    c8.coverage.Record(address, instruction, c8.pc)
+  }
+  return nil
 }
 
 // WIP, only partly implemented
-func (c8 *Chip8) debugInstruction(instruction *utils.Instruction) {
-  c8.executeInstruction(instruction)
+func (c8 *Chip8) debugInstruction(instruction *utils.Instruction) error {
+  if err := c8.executeInstruction(instruction); err != nil {
+    return err
+  }
   if c8.pc == c8.debugBreakpoint {
     c8.debugState = PAUSED
   }
@@ -162,6 +257,7 @@ func (c8 *Chip8) debugInstruction(instruction *utils.Instruction) {
         fmt.Printf("Sorry, %s is not a validcommand", command)
       }
     }
+  return nil
 }
 
 func (c8 *Chip8) prettyPrint() {
@@ -174,29 +270,97 @@ func (c8 *Chip8) prettyPrint() {
 func (c8 *Chip8) safeValuePrint() {
 }
 
-// TODO: write unit tests
-func (c8 *Chip8) Execute() {
-  loopCounter := 0
-  for {
-    instruction := c8.fetchAndDecode()
-    if c8.debug {
-      c8.debugInstruction(&instruction)
-    } else {
-      c8.executeInstruction(&instruction)
+// fetches and executes a single instruction, without touching the timers;
+// for front ends with their own debugger. While FX0A is waiting for a key
+// it checks the keys instead.
+func (c8 *Chip8) Step() error {
+  if c8.keyWait.waiting {
+    c8.waitForKey()
+    return nil
+  }
+  instruction := c8.fetchAndDecode()
+  if c8.debug {
+    return c8.debugInstruction(&instruction)
+  }
+  return c8.executeInstruction(&instruction)
+}
+
+// FX0A, checked every cycle until it's done: waits for a key to go down,
+// then, unless the KeyPress quirk is set, for it to come back up like on the
+// COSMAC VIP, which beeps while the key is held
+func (c8 *Chip8) waitForKey() {
+  if c8.keyWait.key < 0 {
+    for key := range c8.Keyboard {
+      if c8.Keyboard[key].Pressed {
+        c8.keyWait.key = int8(key)
+      }
     }
-    // TODO: run some tests to make sure this works like its supposed to, not sure
-    // if the timing is right
-    // TODO: also think if i want to edit the timing of key presses and how that works
-    // maybe await key instruction i want on key press and release?
-    if c8.delayTimer > 0 && loopCounter % int(c8.clockSpeed/DELAY_SOUND_TIMER_UPDATE) == 0 {
-      c8.delayTimer -= 1
+    if c8.keyWait.key < 0 || !c8.quirks.KeyPress {
+      return
+    }
+  } else if c8.Keyboard[c8.keyWait.key].Pressed {
+    return
+  }
+  c8.variableRegister[c8.keyWait.register] = uint8(c8.keyWait.key)
+  c8.keyWait = keyWait{}
+}
+
+// true while FX0A is waiting for a key
+func (c8 *Chip8) WaitingForKey() bool {
+  return c8.keyWait.waiting
+}
+
+// the instruction at pc, which Step will execute next
+func (c8 *Chip8) NextInstruction() utils.Instruction {
+  codedInstruction := (uint16(c8.memory[c8.wrap(c8.pc)]) << 8) | uint16(c8.memory[c8.wrap(c8.pc+1)])
+  return utils.InstructionFromBytecode(codedInstruction)
+}
+
+// runs one 60Hz frame worth of instructions, then ticks the timers once.
+// Execute calls this in real time, but headless callers can step it as fast as they like.
+// The attached front end is polled for keys first and handed the screen and beep after.
+// Returns an error if the program hits an invalid instruction or breaks the stack.
+func (c8 *Chip8) RunFrame() error {
+  c8.pollInput()
+  for cycle := uint16(0); cycle < c8.clockSpeed/DELAY_SOUND_TIMER_UPDATE; cycle++ {
+    if err := c8.Step(); err != nil {
+      return err
     }
-    // TODO: implement sound!
-    if c8.soundTimer > 0 && loopCounter % int(c8.clockSpeed/DELAY_SOUND_TIMER_UPDATE) == 0 {
-      c8.soundTimer -= 1
+  }
+  // TODO: also think if i want to edit the timing of key presses and how that works
+  // maybe await key instruction i want on key press and release?
+  if c8.delayTimer > 0 {
+    c8.delayTimer -= 1
+  }
+  if c8.soundTimer > 0 {
+    c8.soundTimer -= 1
+  }
+  c8.presentFrame()
+  c8.frame++
+  return nil
+}
+
+// runs frames at 60Hz forever, exiting if the ROM hits an error
+func (c8 *Chip8) Execute() {
+  if err := c8.ExecuteUntil(nil); err != nil {
+    log.Fatal(err)
+  }
+}
+
+// like Execute, but returns once stop is closed, e.g. when the launcher
+// switches to another ROM, or with the error if the ROM hits one
+func (c8 *Chip8) ExecuteUntil(stop <-chan struct{}) error {
+  ticker := time.NewTicker(time.Second / time.Duration(DELAY_SOUND_TIMER_UPDATE))
+  defer ticker.Stop()
+  for {
+    select {
+    case <-stop:
+      return nil
+    case <-ticker.C:
+      if err := c8.RunFrame(); err != nil {
+        return err
+      }
     }
-    time.Sleep(time.Duration(1000/c8.clockSpeed) * time.Millisecond)
-    loopCounter += 1
   }
 }
 
@@ -220,15 +384,15 @@ var font = []uint8{
         0xF0, 0x80, 0xF0, 0x80, 0x80,  // F
 }
 
-func NewChip8(debug bool, modern bool) *Chip8 {
+func NewChip8(debug bool, quirks Quirks) *Chip8 {
   // load font into memory starting at FONT_START
-  memory := [4096]byte{}
+  memory := [XO_CHIP_MEMORY_SIZE]byte{}
 
   for index, element := range font {
     memory[FONT_START + uint16(index)] = element
   }
 
-  instructionMap := map[uint8]func(*utils.Instruction){}
+  instructionMap := map[uint8]func(*utils.Instruction) error{}
 
   var debugState DebugState
   if debug {
@@ -241,7 +405,7 @@ func NewChip8(debug bool, modern bool) *Chip8 {
 
   keyboard := new([16]keypress)
 
-  c8 := Chip8{PROGRAM_START, 0, 0, 0, [32*64]uint8{}, utils.Stack{}, [16]uint8{}, memory, instructionMap, keyboard, CLOCK_SPEED, modern, debug, debugState, debugBreakpoint}
+  c8 := Chip8{PROGRAM_START, 0, 0, 0, [DISPLAY_HEIGHT*DISPLAY_WIDTH]uint8{}, utils.Stack{}, [16]uint8{}, memory, instructionMap, keyboard, CLOCK_SPEED, quirks, debug, debugState, debugBreakpoint, nil, 0, FrontEnd{}, [AUDIO_PATTERN_SIZE]byte{}, false, DEFAULT_PITCH, keyWait{}, 0, nil, CHIP_8}
 
   // put instructions in a map
   c8.instructionMap[0x0] = c8.I0
@@ -263,3 +427,16 @@ func NewChip8(debug bool, modern bool) *Chip8 {
 
   return &c8
 }
+
+// a Chip8 whose registers start out as state rather than at power-on,
+// so tests can set up a single instruction without running a program first
+func NewChip8WithState(quirks Quirks, state State) *Chip8 {
+  c8 := NewChip8(false, quirks)
+  c8.pc = state.PC
+  c8.i = state.I
+  c8.variableRegister = state.V
+  c8.stack = append(utils.Stack{}, state.Stack...)
+  c8.delayTimer = state.DelayTimer
+  c8.soundTimer = state.SoundTimer
+  return c8
+}
@@ -0,0 +1,44 @@
+package cpu
+
+import (
+  "testing"
+  "time"
+)
+
+func TestExecuteUntil(t *testing.T) {
+  c8 := NewChip8(false, MODERN_QUIRKS)
+  // loop forever
+  copy(c8.Memory()[PROGRAM_START:], []byte{0x12, 0x00})
+  stop, done := make(chan struct{}), make(chan error)
+  go func() {
+    done <- c8.ExecuteUntil(stop)
+  }()
+  time.Sleep(100 * time.Millisecond)
+  close(stop)
+  select {
+  case err := <-done:
+    if err != nil {
+      t.Fatal(err)
+    }
+  case <-time.After(time.Second):
+    t.Fatal("still running after stop was closed")
+  }
+  if c8.Frame() == 0 {
+    t.Error("didn't run any frames")
+  }
+
+  // returning with nothing on the stack
+  c8 = NewChip8(false, MODERN_QUIRKS)
+  copy(c8.Memory()[PROGRAM_START:], []byte{0x00, 0xEE})
+  go func() {
+    done <- c8.ExecuteUntil(nil)
+  }()
+  select {
+  case err := <-done:
+    if err == nil {
+      t.Error("got no error from a bad return")
+    }
+  case <-time.After(time.Second):
+    t.Fatal("kept running after an error")
+  }
+}
@@ -0,0 +1,100 @@
+package cpu
+
+// A front end is whatever shows the screen, plays the beep and reads the
+// keypad: an ebiten window, a terminal, a headless test. The core drives it
+// from RunFrame, once per 60Hz frame, so front ends never need to reach into Chip8.
+
+// gets the framebuffer at the end of every frame
+type VideoSink interface {
+  // pixels is width*height values row by row, 0 for off; it's only valid
+  // during the call, so copy it to keep it
+  DrawFrame(pixels []uint8, width int, height int)
+}
+
+// gets whether the beep should be sounding at the end of every frame
+type AudioSink interface {
+  SetTone(on bool)
+}
+
+// an AudioSink that can also play XO-CHIP audio patterns. Once a ROM loads a
+// pattern with F002, SetPattern is called before every SetTone.
+type PatternSink interface {
+  AudioSink
+  // pattern is 128 1 bit samples, most significant bit first, played in a
+  // loop at 4000*2^((pitch-64)/48) samples per second
+  SetPattern(pattern [AUDIO_PATTERN_SIZE]byte, pitch uint8)
+}
+
+// asked which keys are held at the start of every frame; see also EventSource
+type InputSource interface {
+  // set keys[n] for each of the 16 keys that is down
+  PollKeys(keys *[16]bool)
+}
+
+// any of these can be nil, e.g. a headless run with no audio
+type FrontEnd struct {
+  Video VideoSink
+  Audio AudioSink
+  Input InputSource
+}
+
+// connects a front end, replacing any attached before
+func (c8 *Chip8) Attach(frontEnd FrontEnd) {
+  c8.frontEnd = frontEnd
+}
+
+// Updates the keypad for the start of a frame. Keys only change here, so
+// EX9E, EXA1 and FX0A all see the same keys for a whole frame. Events from
+// an EventSource are applied at most one per key per frame, so a tap that
+// went down and up between two frames is still down for one.
+func (c8 *Chip8) pollInput() {
+  if c8.frontEnd.Input == nil {
+    return
+  }
+  if events, ok := c8.frontEnd.Input.(EventSource); ok {
+    c8.pendingKeys = append(c8.pendingKeys, events.KeyEvents(c8.frame)...)
+    // clears last frame's JustReleased
+    for key := range c8.Keyboard {
+      c8.SetKey(uint8(key), c8.Keyboard[key].Pressed)
+    }
+    changed := [16]bool{}
+    remaining := c8.pendingKeys[:0]
+    for _, event := range c8.pendingKeys {
+      if changed[event.Key] {
+        remaining = append(remaining, event)
+        continue
+      }
+      if c8.Keyboard[event.Key].Pressed != event.Pressed {
+        c8.SetKey(event.Key, event.Pressed)
+        changed[event.Key] = true
+      }
+    }
+    c8.pendingKeys = remaining
+    return
+  }
+  keys := [16]bool{}
+  c8.frontEnd.Input.PollKeys(&keys)
+  for key, pressed := range keys {
+    c8.SetKey(uint8(key), pressed)
+  }
+}
+
+func (c8 *Chip8) presentFrame() {
+  if c8.frontEnd.Video != nil {
+    width, height := c8.Resolution()
+    c8.frontEnd.Video.DrawFrame(c8.Display[:], width, height)
+  }
+  if c8.frontEnd.Audio != nil {
+    if patternSink, ok := c8.frontEnd.Audio.(PatternSink); ok && c8.hasAudioPattern {
+      patternSink.SetPattern(c8.audioPattern, c8.pitch)
+    }
+    // the VIP also beeps while FX0A has a key held down
+    c8.frontEnd.Audio.SetTone(c8.soundTimer > 0 || (c8.keyWait.waiting && c8.keyWait.key >= 0))
+  }
+}
+
+// hands the screen and beep to the front end now, for debuggers that
+// Step between frames and want to see the result
+func (c8 *Chip8) Refresh() {
+  c8.presentFrame()
+}
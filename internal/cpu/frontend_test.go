@@ -0,0 +1,91 @@
+package cpu
+
+import (
+  "testing"
+)
+
+type fakeFrontEnd struct {
+  keys [16]bool
+  pixels []uint8
+  tone bool
+}
+
+func (f *fakeFrontEnd) PollKeys(keys *[16]bool) {
+  *keys = f.keys
+}
+
+func (f *fakeFrontEnd) DrawFrame(pixels []uint8, width int, height int) {
+  f.pixels = append([]uint8{}, pixels...)
+}
+
+func (f *fakeFrontEnd) SetTone(on bool) {
+  f.tone = on
+}
+
+func TestFrontEnd(t *testing.T) {
+  c8 := NewChip8(false, MODERN_QUIRKS)
+  // beeps while key 5 is held
+  copy(c8.Memory()[PROGRAM_START:], []byte{
+    0x60, 0x05, // V0 = 5
+    0xE0, 0xA1, // skip if key V0 isn't pressed
+    0xF0, 0x18, // sound timer = V0
+    0x12, 0x02, // jump back to the key check
+  })
+  c8.Display[0] = 1
+  fake := &fakeFrontEnd{}
+  c8.Attach(FrontEnd{Video: fake, Audio: fake, Input: fake})
+
+  if err := c8.RunFrame(); err != nil {
+    t.Fatal(err)
+  }
+  if fake.tone {
+    t.Error("tone on with no key held")
+  }
+  if len(fake.pixels) != DISPLAY_WIDTH*DISPLAY_HEIGHT || fake.pixels[0] != 1 {
+    t.Error("the video sink didn't get the framebuffer")
+  }
+
+  fake.keys[5] = true
+  if err := c8.RunFrame(); err != nil {
+    t.Fatal(err)
+  }
+  if !fake.tone {
+    t.Error("tone off with key 5 held")
+  }
+}
+
+func TestKeyQueueTap(t *testing.T) {
+  c8 := NewChip8(false, MODERN_QUIRKS)
+  // loop forever
+  copy(c8.Memory()[PROGRAM_START:], []byte{0x12, 0x00})
+  queue := &KeyQueue{}
+  c8.Attach(FrontEnd{Input: queue})
+  run := func() {
+    if err := c8.RunFrame(); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  run()
+  // a tap between two frames
+  queue.Push(0x5, true)
+  queue.Push(0x5, false)
+  run()
+  if !c8.Keyboard[0x5].Pressed {
+    t.Fatal("a tap between frames should hold the key down for a frame")
+  }
+  run()
+  if c8.Keyboard[0x5].Pressed || !c8.Keyboard[0x5].JustReleased {
+    t.Error("the key should be released the frame after")
+  }
+  run()
+  if c8.Keyboard[0x5].JustReleased {
+    t.Error("JustReleased should only last a frame")
+  }
+
+  // pushed while frame 3 was the last one started
+  queue.Push(0x1, true)
+  if events := queue.KeyEvents(c8.Frame()); len(events) != 1 || events[0].Frame != 3 {
+    t.Errorf("got %v, want key 1 stamped with frame 3", events)
+  }
+}
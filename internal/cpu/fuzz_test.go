@@ -0,0 +1,45 @@
+package cpu
+
+import (
+  "testing"
+
+  "jfeintzeig/chip8/internal/utils"
+)
+
+// runs random ROMs for a bounded number of frames; errors are fine, panics aren't.
+// Crashers end up in testdata/fuzz/FuzzRun and are replayed by go test.
+func FuzzRun(f *testing.F) {
+  f.Add([]byte{0x00, 0xE0, 0x60, 0x05, 0xF0, 0x29, 0xD0, 0x15, 0x12, 0x06})
+  f.Fuzz(func(t *testing.T, rom []byte) {
+    c8 := NewChip8(false, MODERN_QUIRKS)
+    copy(c8.Memory()[PROGRAM_START:], rom)
+    for frame := 0; frame < 50; frame++ {
+      if err := c8.RunFrame(); err != nil {
+        return
+      }
+    }
+  })
+}
+
+func TestStackErrors(t *testing.T) {
+  c8 := NewChip8WithState(MODERN_QUIRKS, State{PC: 0x202})
+  inst := utils.InstructionFromBytecode(0x00EE)
+  if err := c8.executeInstruction(&inst); err == nil {
+    t.Error("return with an empty stack should fail")
+  }
+
+  c8 = NewChip8WithState(MODERN_QUIRKS, State{PC: 0x202, Stack: make([]uint16, utils.STACK_SIZE)})
+  inst = utils.InstructionFromBytecode(0x2300)
+  if err := c8.executeInstruction(&inst); err == nil {
+    t.Error("call with a full stack should fail")
+  }
+}
+
+func TestFetchWraps(t *testing.T) {
+  c8 := NewChip8WithState(MODERN_QUIRKS, State{PC: MEMORY_SIZE - 1})
+  c8.Memory()[MEMORY_SIZE-1] = 0x12
+  c8.Memory()[0] = 0x34
+  if inst := c8.fetchAndDecode(); inst.Full != 0x1234 {
+    t.Errorf("fetching across the end of memory got %04X, want 1234", inst.Full)
+  }
+}
@@ -0,0 +1,115 @@
+package cpu_test
+
+import (
+  "bytes"
+  "errors"
+  "flag"
+  "image/png"
+  "os"
+  "path/filepath"
+  "testing"
+
+  "jfeintzeig/chip8/internal/cpu"
+  "jfeintzeig/chip8/internal/screen"
+  "jfeintzeig/chip8/internal/script"
+)
+
+var update = flag.Bool("update", false, "regenerate the golden images in testdata/golden")
+
+// golden images are saved at this scale so they're easy to look at
+const GOLDEN_SCALE = 4
+
+type goldenTest struct {
+  // testdata/roms/<name>.ch8, compared against testdata/golden/<name>.png
+  name string
+  frames int
+  quirks cpu.Quirks
+  // true for ROMs we don't check in, which are skipped if missing; see
+  // testdata/roms/README
+  optional bool
+}
+
+var goldenTests = []goldenTest{
+  {"font", 30, cpu.MODERN_QUIRKS, false},
+  {"bcd", 30, cpu.MODERN_QUIRKS, false},
+  {"ibm_logo", 60, cpu.MODERN_QUIRKS, false},
+  {"corax_plus", 120, cpu.MODERN_QUIRKS, true},
+  {"flags", 120, cpu.MODERN_QUIRKS, true},
+  {"quirks", 300, cpu.LEGACY_QUIRKS, true},
+  {"keypad", 120, cpu.MODERN_QUIRKS, true},
+}
+
+func runGolden(t *testing.T, test goldenTest) screen.Frame {
+  romPath := filepath.Join("testdata", "roms", test.name + ".ch8")
+  if _, err := os.Stat(romPath); err != nil {
+    if test.optional && errors.Is(err, os.ErrNotExist) {
+      t.Skipf("%s not found, run scripts/fetch-test-roms.sh", romPath)
+    }
+    t.Fatal(err)
+  }
+
+  input := script.Script{}
+  keysPath := filepath.Join("testdata", "roms", test.name + ".keys")
+  if _, err := os.Stat(keysPath); err == nil {
+    if input, err = script.Load(keysPath); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  chip8 := cpu.NewChip8(false, test.quirks)
+  if err := chip8.LoadFile(romPath); err != nil {
+    t.Fatal(err)
+  }
+  for frame := 0; frame < test.frames; frame++ {
+    input.Apply(frame, chip8)
+    if err := chip8.RunFrame(); err != nil {
+      t.Fatal(err)
+    }
+  }
+  return screen.NewFrame(chip8.Display[:], cpu.DISPLAY_WIDTH, cpu.DISPLAY_HEIGHT)
+}
+
+func TestGoldenScreens(t *testing.T) {
+  for _, test := range goldenTests {
+    test := test
+    t.Run(test.name, func(t *testing.T) {
+      frame := runGolden(t, test)
+      goldenPath := filepath.Join("testdata", "golden", test.name + ".png")
+
+      var got bytes.Buffer
+      if err := frame.WritePNG(&got, GOLDEN_SCALE); err != nil {
+        t.Fatal(err)
+      }
+      if *update {
+        if err := os.WriteFile(goldenPath, got.Bytes(), 0644); err != nil {
+          t.Fatal(err)
+        }
+        return
+      }
+
+      f, err := os.Open(goldenPath)
+      if err != nil {
+        t.Fatalf("%v; run go test -update to create it", err)
+      }
+      defer f.Close()
+      want, err := png.Decode(f)
+      if err != nil {
+        t.Fatal(err)
+      }
+
+      // compare pixels rather than files, so the PNG encoder can change under us
+      gotImage := frame.Image(GOLDEN_SCALE)
+      if want.Bounds() != gotImage.Bounds() {
+        t.Fatalf("golden image is %v, screen is %v", want.Bounds(), gotImage.Bounds())
+      }
+      for y := 0; y < frame.Height; y++ {
+        for x := 0; x < frame.Width; x++ {
+          r, _, _, _ := want.At(x*GOLDEN_SCALE, y*GOLDEN_SCALE).RGBA()
+          if (r != 0) != (frame.At(x, y) != 0) {
+            t.Fatalf("pixel (%d, %d) differs from %s, got screen:\n%s", x, y, goldenPath, frame.ASCII())
+          }
+        }
+      }
+    })
+  }
+}
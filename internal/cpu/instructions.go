@@ -1,13 +1,13 @@
 package cpu
 
 import (
-  "log"
+  "fmt"
   "math/rand"
 
   "jfeintzeig/chip8/internal/utils"
 )
 
-func (c8 *Chip8) I0(inst *utils.Instruction) {
+func (c8 *Chip8) I0(inst *utils.Instruction) error {
   switch inst.NN {
   // 00E0: clear screen
   case 0xE0:
@@ -15,63 +15,78 @@ func (c8 *Chip8) I0(inst *utils.Instruction) {
   // 00EE: return from subroutine
   // pop stack and set pc to value
   case 0xEE:
-    c8.stack, c8.pc = c8.stack.Pop()
+    var err error
+    if c8.stack, c8.pc, err = c8.stack.Pop(); err != nil {
+      return err
+    }
   default:
-    log.Fatal("unknown last nibble for I0 instruction.")
+    return fmt.Errorf("unknown instruction %04X", inst.Full)
   }
+  return nil
 }
 
 // jump
-func (c8 *Chip8) I1NNN(inst *utils.Instruction) {
+func (c8 *Chip8) I1NNN(inst *utils.Instruction) error {
   c8.pc = inst.NNN
+  return nil
 }
 
 // enter subroutine: store pc in stack and jump
-func (c8 *Chip8) I2NNN(inst *utils.Instruction) {
-  c8.stack = c8.stack.Push(c8.pc)
+func (c8 *Chip8) I2NNN(inst *utils.Instruction) error {
+  var err error
+  if c8.stack, err = c8.stack.Push(c8.pc); err != nil {
+    return err
+  }
   c8.pc = inst.NNN
+  return nil
 }
 
 // skip instruction if VX == NN
-func (c8 *Chip8) I3XNN(inst *utils.Instruction) {
+func (c8 *Chip8) I3XNN(inst *utils.Instruction) error {
   if c8.variableRegister[inst.X] == inst.NN {
     c8.pc += 2
   }
+  return nil
 }
 
 // skip instruction if VX != NN
-func (c8 *Chip8) I4XNN(inst *utils.Instruction) {
+func (c8 *Chip8) I4XNN(inst *utils.Instruction) error {
   if c8.variableRegister[inst.X] != inst.NN {
     c8.pc += 2
   }
+  return nil
 }
 
 // skip instruction if VX == VY
-func (c8 *Chip8) I5XY0(inst *utils.Instruction) {
+func (c8 *Chip8) I5XY0(inst *utils.Instruction) error {
   if c8.variableRegister[inst.X] == c8.variableRegister[inst.Y] {
     c8.pc += 2
   }
+  return nil
 }
 
 // skip instruction if VX != VY
-func (c8 *Chip8) I9XY0(inst *utils.Instruction) {
+func (c8 *Chip8) I9XY0(inst *utils.Instruction) error {
   if c8.variableRegister[inst.X] != c8.variableRegister[inst.Y] {
     c8.pc += 2
   }
+  return nil
 }
 
 // set register VX to NN
-func (c8 *Chip8) I6XNN(inst *utils.Instruction) {
+func (c8 *Chip8) I6XNN(inst *utils.Instruction) error {
   c8.variableRegister[inst.X] = inst.NN
+  return nil
 }
 
 // add NN to register VX
-func (c8 *Chip8) I7XNN(inst *utils.Instruction) {
+func (c8 *Chip8) I7XNN(inst *utils.Instruction) error {
   c8.variableRegister[inst.X] += inst.NN
+  return nil
 }
 
 // logic and arithmetic
-func (c8 *Chip8) I8XYN(inst *utils.Instruction) {
+func (c8 *Chip8) I8XYN(inst *utils.Instruction) error {
   switch inst.N {
   // 8XY0: set VX to VY
   case 0:
@@ -79,19 +94,19 @@ func (c8 *Chip8) I8XYN(inst *utils.Instruction) {
   // 8XY1: set VX to (VX | VY)
   case 1:
     c8.variableRegister[inst.X] |= c8.variableRegister[inst.Y]
-    if !c8.modern {
+    if c8.quirks.ResetVF {
       c8.variableRegister[0xF] = 0
     }
   // 8XY2: set VX to (VX & VY)
   case 2:
     c8.variableRegister[inst.X] &= c8.variableRegister[inst.Y]
-    if !c8.modern {
+    if c8.quirks.ResetVF {
       c8.variableRegister[0xF] = 0
     }
   // 8XY3: set VX to (VX XOR VY)
   case 3:
     c8.variableRegister[inst.X] ^= c8.variableRegister[inst.Y]
-    if !c8.modern {
+    if c8.quirks.ResetVF {
       c8.variableRegister[0xF] = 0
     }
   // 8XY4: set VX to (VX + VY)
@@ -102,34 +117,34 @@ func (c8 *Chip8) I8XYN(inst *utils.Instruction) {
     }
     c8.variableRegister[inst.X] += c8.variableRegister[inst.Y]
     c8.variableRegister[0xF] = carry
-  // 8XY5: set VX to (VX - VY)
+  // 8XY5: set VX to (VX - VY), VF is 1 unless it borrowed
   case 5:
     carry := uint8(0)
-    if c8.variableRegister[inst.X] > c8.variableRegister[inst.Y] {
+    if c8.variableRegister[inst.X] >= c8.variableRegister[inst.Y] {
       carry = 1
     }
     c8.variableRegister[inst.X] -= c8.variableRegister[inst.Y]
     c8.variableRegister[0xF] = carry
   // 8XY6: shift VX one bit right
   case 6:
-    if !c8.modern {
+    if c8.quirks.ShiftVY {
       c8.variableRegister[inst.X] = c8.variableRegister[inst.Y]
     }
 
     rightMostBit := c8.variableRegister[inst.X] & 1
     c8.variableRegister[inst.X] = c8.variableRegister[inst.X] >> 1
     c8.variableRegister[0xF] = rightMostBit
-  // 8XY7: set VX to (VY - VX)
+  // 8XY7: set VX to (VY - VX), VF is 1 unless it borrowed
   case 7:
     carry := uint8(0)
-    if c8.variableRegister[inst.Y] > c8.variableRegister[inst.X] {
+    if c8.variableRegister[inst.Y] >= c8.variableRegister[inst.X] {
       carry = 1
     }
     c8.variableRegister[inst.X] = c8.variableRegister[inst.Y] - c8.variableRegister[inst.X]
     c8.variableRegister[0xF] = carry
   // 8XYE: shift VX one bit left
   case 0xE:
-    if !c8.modern {
+    if c8.quirks.ShiftVY {
       c8.variableRegister[inst.X] = c8.variableRegister[inst.Y]
     }
 
@@ -137,45 +152,47 @@ func (c8 *Chip8) I8XYN(inst *utils.Instruction) {
     c8.variableRegister[inst.X] = c8.variableRegister[inst.X] << 1
     c8.variableRegister[0xF] = leftMostBit
   default:
-    log.Fatal("unknown last nibble for I8XYN instruction.")
+    return fmt.Errorf("unknown instruction %04X", inst.Full)
   }
+  return nil
 }
 
 // set index register to NNN
-func (c8 *Chip8) IANNN(inst *utils.Instruction) {
+func (c8 *Chip8) IANNN(inst *utils.Instruction) error {
   c8.i = inst.NNN
+  return nil
 }
 
-// jump to NNN + V0
-func (c8 *Chip8) IBNNN(inst *utils.Instruction) {
-  // TODO: the blog suggests non-modern is the preferred mode for this one,
-  // but modern is the preferred mode for I8XYE and I8XY6? how to deal with this?
-  if c8.modern {
+// jump to NNN + V0, or XNN + VX with the JumpVX quirk
+func (c8 *Chip8) IBNNN(inst *utils.Instruction) error {
+  if c8.quirks.JumpVX {
    c8.pc = inst.NNN + uint16(c8.variableRegister[inst.X])
   } else {
    c8.pc = inst.NNN + uint16(c8.variableRegister[0])
   }
+  return nil
 }
 
 // random number, and with NN, put at VX
-func (c8 *Chip8) ICXNN(inst *utils.Instruction) {
+func (c8 *Chip8) ICXNN(inst *utils.Instruction) error {
   c8.variableRegister[inst.X] = uint8(rand.Intn(256)) & inst.NN
+  return nil
 }
 
 // draw Display
-func (c8 *Chip8) IDXYN(inst *utils.Instruction) {
+func (c8 *Chip8) IDXYN(inst *utils.Instruction) error {
   // choosing x+y starting place wraps the screen
-  x := int(c8.variableRegister[inst.X] % 64)
-  y := int(c8.variableRegister[inst.Y] % 32)
+  x := int(c8.variableRegister[inst.X] % DISPLAY_WIDTH)
+  y := int(c8.variableRegister[inst.Y] % DISPLAY_HEIGHT)
   c8.variableRegister[0xF] = 0
   for i := 0; i < int(inst.N); i++ {
-    sprite := c8.memory[c8.i + uint16(i)]
+    sprite := c8.memory[c8.wrap(c8.i + uint16(i))]
     // for bit in sprite, loop over display and xor sprite bit and memory bit
     for spriteBit := 0; spriteBit < 8; spriteBit++ {
       // if part of the sprite is over the edge of the screen, clip it
-      if ((x + spriteBit) < 64) && ((y + i) < 32) {
-        // we have a 1d array representing a 2d screen; each 64 values is a row.
-        index := (y+i)*64 + (x+spriteBit)
+      if ((x + spriteBit) < DISPLAY_WIDTH) && ((y + i) < DISPLAY_HEIGHT) {
+        // we have a 1d array representing a 2d screen; each DISPLAY_WIDTH values is a row.
+        index := (y+i)*DISPLAY_WIDTH + (x+spriteBit)
         // change display by xor'ing pixel with corresponding bit in sprite
         displayPixel := c8.Display[index]
         spritePixel := ((sprite >> (7-spriteBit)) & 0x01)
@@ -186,13 +203,14 @@ func (c8 *Chip8) IDXYN(inst *utils.Instruction) {
       }
     }
   }
+  return nil
 }
 
 // key presses
-func (c8 *Chip8) IE(inst *utils.Instruction) {
+func (c8 *Chip8) IE(inst *utils.Instruction) error {
   key := c8.variableRegister[inst.X]
   if key > 0xF {
-    log.Fatalf("Unknown key %x", key)
+    return fmt.Errorf("unknown key %X in V%X", key, inst.X)
   }
 
   switch inst.NN {
@@ -207,12 +225,13 @@ func (c8 *Chip8) IE(inst *utils.Instruction) {
       c8.pc += 2
     }
   default:
-    log.Fatal("unknown last nibble for IE instruction.")
+    return fmt.Errorf("unknown instruction %04X", inst.Full)
   }
+  return nil
 }
 
 // timers, fonts, keys, other stuff
-func (c8 *Chip8) IF(inst *utils.Instruction) {
+func (c8 *Chip8) IF(inst *utils.Instruction) error {
   switch inst.NN {
   // FX07: set VX to delay timer
   case 0x07:
@@ -229,32 +248,10 @@ func (c8 *Chip8) IF(inst *utils.Instruction) {
       c8.variableRegister[0xF] = 1
     }
     c8.i += uint16(c8.variableRegister[inst.X])
-  // FX0A: block until key press, then store key in VX
+  // FX0A: wait for a key, then store it in VX; see waitForKey
   case 0x0A:
-    isKeyPressed := false
-    key := uint8(0)
-    for index, element := range c8.Keyboard {
-      if element.Pressed {
-        isKeyPressed = true
-        key = uint8(index)
-      }
-    }
-    if isKeyPressed {
-    // Note: this blocks any cycles while it waits for key release
-    // vs. the "wait for keypress" functionality of this function
-    // actually returns so cycles run. The result is that timers
-    // will continue decrementing until key press, then they will
-    // pause until key release.
-    OuterLoop:
-      for true {
-        if c8.Keyboard[key].JustReleased {
-          break OuterLoop
-        }
-      }
-    c8.variableRegister[inst.X] = key
-    } else {
-      c8.pc -= 2
-    }
+    c8.keyWait = keyWait{waiting: true, register: inst.X, key: -1}
+    c8.waitForKey()
   // FX29: set index register to location of font character corresponding to last nibble of VX
   case 0x29:
     c8.i = FONT_START + 5 * uint16(c8.variableRegister[inst.X] & 0x0F)
@@ -265,26 +262,39 @@ func (c8 *Chip8) IF(inst *utils.Instruction) {
   //     memory[i+2] = 5
   case 0x33:
     vx := c8.variableRegister[inst.X]
-    c8.memory[c8.i] = vx / 100
-    c8.memory[c8.i+1] = vx / 10 - (vx / 100)*10
-    c8.memory[c8.i+2] = vx - (vx / 100)*100 - (vx / 10 - (vx / 100)*10)*10
+    c8.memory[c8.wrap(c8.i)] = vx / 100
+    c8.memory[c8.wrap(c8.i+1)] = vx / 10 - (vx / 100)*10
+    c8.memory[c8.wrap(c8.i+2)] = vx - (vx / 100)*100 - (vx / 10 - (vx / 100)*10)*10
   // FX55: Write variable register from V0 to VX (inclusive) into consecutive memory bytes, starting at index register address.
   case 0x55:
     for index := uint16(0); index <= uint16(inst.X); index++ {
-      c8.memory[c8.i + index] = c8.variableRegister[index]
+      c8.memory[c8.wrap(c8.i + index)] = c8.variableRegister[index]
     }
-    if !c8.modern {
+    if c8.quirks.IncrementI {
       c8.i += uint16(inst.X) + 1
     }
   // FX65: Write X+1 consecutive memory bytes, starting at index register address, into variable register from V0 to VX, inclusive.
   case 0x65:
     for index := uint16(0); index <= uint16(inst.X); index++ {
-      c8.variableRegister[index] = c8.memory[c8.i + index]
+      c8.variableRegister[index] = c8.memory[c8.wrap(c8.i + index)]
     }
-    if !c8.modern {
+    if c8.quirks.IncrementI {
       c8.i += uint16(inst.X) + 1
     }
+  // F002: XO-CHIP, load the 16 byte audio pattern from memory starting at index register
+  case 0x02:
+    if inst.X != 0 {
+      return fmt.Errorf("unknown instruction %04X", inst.Full)
+    }
+    for index := uint16(0); index < AUDIO_PATTERN_SIZE; index++ {
+      c8.audioPattern[index] = c8.memory[c8.wrap(c8.i + index)]
+    }
+    c8.hasAudioPattern = true
+  // FX3A: XO-CHIP, set the audio pattern's playback pitch to VX
+  case 0x3A:
+    c8.pitch = c8.variableRegister[inst.X]
   default:
-    log.Fatal("uknown instruction %x for FX", inst.Full)
+    return fmt.Errorf("unknown instruction %04X", inst.Full)
   }
+  return nil
 }
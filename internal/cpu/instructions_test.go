@@ -0,0 +1,358 @@
+package cpu
+
+import (
+  "reflect"
+  "testing"
+
+  "jfeintzeig/chip8/internal/utils"
+)
+
+// which interpretations of ambiguous instructions a test case runs under
+var (
+  BOTH = []Quirks{MODERN_QUIRKS, LEGACY_QUIRKS}
+  MODERN = []Quirks{MODERN_QUIRKS}
+  LEGACY = []Quirks{LEGACY_QUIRKS}
+)
+
+type instructionTest struct {
+  name string
+  modes []Quirks
+  instruction uint16
+  before State
+  // poked into memory before executing
+  memory map[uint16]byte
+  // applied to a copy of before to get the state we expect afterwards
+  after func(*State)
+  wantMemory map[uint16]byte
+}
+
+// a register file with V0 upwards set to values and the rest 0
+func v(values ...uint8) [16]uint8 {
+  registers := [16]uint8{}
+  copy(registers[:], values)
+  return registers
+}
+
+var instructionTests = []instructionTest{
+  // 00EE
+  {name: "return pops the stack", modes: BOTH, instruction: 0x00EE,
+    before: State{PC: 0x30A, Stack: []uint16{0x204, 0x210}},
+    after: func(s *State) { s.PC = 0x210; s.Stack = []uint16{0x204} }},
+  // 1NNN, 2NNN
+  {name: "jump", modes: BOTH, instruction: 0x1ABC,
+    before: State{PC: 0x202},
+    after: func(s *State) { s.PC = 0xABC }},
+  {name: "call pushes the return address", modes: BOTH, instruction: 0x2ABC,
+    before: State{PC: 0x202, Stack: []uint16{0x300}},
+    after: func(s *State) { s.PC = 0xABC; s.Stack = []uint16{0x300, 0x202} }},
+  // 3XNN, 4XNN, 5XY0, 9XY0
+  {name: "skipe equal skips", modes: BOTH, instruction: 0x3342,
+    before: State{PC: 0x202, V: v(0, 0, 0, 0x42)},
+    after: func(s *State) { s.PC = 0x204 }},
+  {name: "skipe not equal doesn't skip", modes: BOTH, instruction: 0x3342,
+    before: State{PC: 0x202, V: v(0, 0, 0, 0x41)},
+    after: func(s *State) {}},
+  {name: "skipne not equal skips", modes: BOTH, instruction: 0x4342,
+    before: State{PC: 0x202, V: v(0, 0, 0, 0x41)},
+    after: func(s *State) { s.PC = 0x204 }},
+  {name: "skipne equal doesn't skip", modes: BOTH, instruction: 0x4342,
+    before: State{PC: 0x202, V: v(0, 0, 0, 0x42)},
+    after: func(s *State) {}},
+  {name: "skipre equal skips", modes: BOTH, instruction: 0x5120,
+    before: State{PC: 0x202, V: v(0, 7, 7)},
+    after: func(s *State) { s.PC = 0x204 }},
+  {name: "skipre not equal doesn't skip", modes: BOTH, instruction: 0x5120,
+    before: State{PC: 0x202, V: v(0, 7, 8)},
+    after: func(s *State) {}},
+  {name: "skiprne not equal skips", modes: BOTH, instruction: 0x9120,
+    before: State{PC: 0x202, V: v(0, 7, 8)},
+    after: func(s *State) { s.PC = 0x204 }},
+  {name: "skiprne equal doesn't skip", modes: BOTH, instruction: 0x9120,
+    before: State{PC: 0x202, V: v(0, 7, 7)},
+    after: func(s *State) {}},
+  // 6XNN, 7XNN
+  {name: "set", modes: BOTH, instruction: 0x6A5F,
+    before: State{PC: 0x202},
+    after: func(s *State) { s.V[0xA] = 0x5F }},
+  {name: "add wraps without touching VF", modes: BOTH, instruction: 0x7102,
+    before: State{PC: 0x202, V: v(0, 0xFF)},
+    after: func(s *State) { s.V[1] = 0x01 }},
+  // 8XYN
+  {name: "move", modes: BOTH, instruction: 0x8120,
+    before: State{PC: 0x202, V: v(0, 1, 2)},
+    after: func(s *State) { s.V[1] = 2 }},
+  {name: "or modern leaves VF", modes: MODERN, instruction: 0x8121,
+    before: State{PC: 0x202, V: v(0, 0x0C, 0x03, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x5)},
+    after: func(s *State) { s.V[1] = 0x0F }},
+  {name: "or legacy resets VF", modes: LEGACY, instruction: 0x8121,
+    before: State{PC: 0x202, V: v(0, 0x0C, 0x03, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x5)},
+    after: func(s *State) { s.V[1] = 0x0F; s.V[0xF] = 0 }},
+  {name: "and modern leaves VF", modes: MODERN, instruction: 0x8122,
+    before: State{PC: 0x202, V: v(0, 0x0C, 0x06, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x5)},
+    after: func(s *State) { s.V[1] = 0x04 }},
+  {name: "and legacy resets VF", modes: LEGACY, instruction: 0x8122,
+    before: State{PC: 0x202, V: v(0, 0x0C, 0x06, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x5)},
+    after: func(s *State) { s.V[1] = 0x04; s.V[0xF] = 0 }},
+  {name: "xor modern leaves VF", modes: MODERN, instruction: 0x8123,
+    before: State{PC: 0x202, V: v(0, 0x0C, 0x06, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x5)},
+    after: func(s *State) { s.V[1] = 0x0A }},
+  {name: "xor legacy resets VF", modes: LEGACY, instruction: 0x8123,
+    before: State{PC: 0x202, V: v(0, 0x0C, 0x06, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x5)},
+    after: func(s *State) { s.V[1] = 0x0A; s.V[0xF] = 0 }},
+  {name: "addr without carry", modes: BOTH, instruction: 0x8124,
+    before: State{PC: 0x202, V: v(0, 0xF0, 0x0F, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1)},
+    after: func(s *State) { s.V[1] = 0xFF; s.V[0xF] = 0 }},
+  {name: "addr with carry", modes: BOTH, instruction: 0x8124,
+    before: State{PC: 0x202, V: v(0, 0xF0, 0x11)},
+    after: func(s *State) { s.V[1] = 0x01; s.V[0xF] = 1 }},
+  {name: "addr into VF keeps the flag", modes: BOTH, instruction: 0x8F14,
+    before: State{PC: 0x202, V: v(0, 0x11, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xF0)},
+    after: func(s *State) { s.V[0xF] = 1 }},
+  {name: "sub without borrow", modes: BOTH, instruction: 0x8125,
+    before: State{PC: 0x202, V: v(0, 5, 3)},
+    after: func(s *State) { s.V[1] = 2; s.V[0xF] = 1 }},
+  {name: "sub of equal values doesn't borrow", modes: BOTH, instruction: 0x8125,
+    before: State{PC: 0x202, V: v(0, 5, 5)},
+    after: func(s *State) { s.V[1] = 0; s.V[0xF] = 1 }},
+  {name: "sub with borrow", modes: BOTH, instruction: 0x8125,
+    before: State{PC: 0x202, V: v(0, 3, 5, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1)},
+    after: func(s *State) { s.V[1] = 0xFE; s.V[0xF] = 0 }},
+  {name: "subr without borrow", modes: BOTH, instruction: 0x8127,
+    before: State{PC: 0x202, V: v(0, 3, 5)},
+    after: func(s *State) { s.V[1] = 2; s.V[0xF] = 1 }},
+  {name: "subr of equal values doesn't borrow", modes: BOTH, instruction: 0x8127,
+    before: State{PC: 0x202, V: v(0, 5, 5)},
+    after: func(s *State) { s.V[1] = 0; s.V[0xF] = 1 }},
+  {name: "subr with borrow", modes: BOTH, instruction: 0x8127,
+    before: State{PC: 0x202, V: v(0, 5, 3, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1)},
+    after: func(s *State) { s.V[1] = 0xFE; s.V[0xF] = 0 }},
+  {name: "shiftr modern shifts VX", modes: MODERN, instruction: 0x8126,
+    before: State{PC: 0x202, V: v(0, 0x05, 0x80)},
+    after: func(s *State) { s.V[1] = 0x02; s.V[0xF] = 1 }},
+  {name: "shiftr legacy shifts VY", modes: LEGACY, instruction: 0x8126,
+    before: State{PC: 0x202, V: v(0, 0x05, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1)},
+    after: func(s *State) { s.V[1] = 0x40; s.V[0xF] = 0 }},
+  {name: "shiftl modern shifts VX", modes: MODERN, instruction: 0x812E,
+    before: State{PC: 0x202, V: v(0, 0x81, 0x01)},
+    after: func(s *State) { s.V[1] = 0x02; s.V[0xF] = 1 }},
+  {name: "shiftl legacy shifts VY", modes: LEGACY, instruction: 0x812E,
+    before: State{PC: 0x202, V: v(0, 0x81, 0x01, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1)},
+    after: func(s *State) { s.V[1] = 0x02; s.V[0xF] = 0 }},
+  // ANNN, BNNN, CXNN
+  {name: "seti", modes: BOTH, instruction: 0xA123,
+    before: State{PC: 0x202},
+    after: func(s *State) { s.I = 0x123 }},
+  {name: "jump0 modern adds VX", modes: MODERN, instruction: 0xB220,
+    before: State{PC: 0x202, V: v(0x01, 0, 0x10)},
+    after: func(s *State) { s.PC = 0x230 }},
+  {name: "jump0 legacy adds V0", modes: LEGACY, instruction: 0xB220,
+    before: State{PC: 0x202, V: v(0x01, 0, 0x10)},
+    after: func(s *State) { s.PC = 0x221 }},
+  {name: "rand masked with 00", modes: BOTH, instruction: 0xC300,
+    before: State{PC: 0x202, V: v(0, 0, 0, 0xFF)},
+    after: func(s *State) { s.V[3] = 0 }},
+  // FXNN
+  {name: "setfromdelay", modes: BOTH, instruction: 0xF407,
+    before: State{PC: 0x202, DelayTimer: 0x33},
+    after: func(s *State) { s.V[4] = 0x33 }},
+  {name: "settodelay", modes: BOTH, instruction: 0xF415,
+    before: State{PC: 0x202, V: v(0, 0, 0, 0, 0x33)},
+    after: func(s *State) { s.DelayTimer = 0x33 }},
+  {name: "settosound", modes: BOTH, instruction: 0xF418,
+    before: State{PC: 0x202, V: v(0, 0, 0, 0, 0x33)},
+    after: func(s *State) { s.SoundTimer = 0x33 }},
+  {name: "addi leaves VF", modes: BOTH, instruction: 0xF21E,
+    before: State{PC: 0x202, I: 0x300, V: v(0, 0, 0x10, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x7)},
+    after: func(s *State) { s.I = 0x310 }},
+  {name: "addi past FFF sets VF", modes: BOTH, instruction: 0xF21E,
+    before: State{PC: 0x202, I: 0xFFE, V: v(0, 0, 0x03)},
+    after: func(s *State) { s.I = 0x1001; s.V[0xF] = 1 }},
+  {name: "font", modes: BOTH, instruction: 0xF129,
+    before: State{PC: 0x202, V: v(0, 0x1A)},
+    after: func(s *State) { s.I = FONT_START + 5*0xA }},
+  {name: "bcd", modes: BOTH, instruction: 0xF033,
+    before: State{PC: 0x202, I: 0x300, V: v(175)},
+    after: func(s *State) {},
+    wantMemory: map[uint16]byte{0x300: 1, 0x301: 7, 0x302: 5}},
+  {name: "save modern leaves I", modes: MODERN, instruction: 0xF255,
+    before: State{PC: 0x202, I: 0x300, V: v(1, 2, 3, 4)},
+    after: func(s *State) {},
+    wantMemory: map[uint16]byte{0x300: 1, 0x301: 2, 0x302: 3, 0x303: 0}},
+  {name: "save legacy increments I", modes: LEGACY, instruction: 0xF255,
+    before: State{PC: 0x202, I: 0x300, V: v(1, 2, 3, 4)},
+    after: func(s *State) { s.I = 0x303 },
+    wantMemory: map[uint16]byte{0x300: 1, 0x301: 2, 0x302: 3, 0x303: 0}},
+  {name: "load modern leaves I", modes: MODERN, instruction: 0xF265,
+    before: State{PC: 0x202, I: 0x300},
+    memory: map[uint16]byte{0x300: 1, 0x301: 2, 0x302: 3, 0x303: 4},
+    after: func(s *State) { s.V = v(1, 2, 3) }},
+  {name: "load legacy increments I", modes: LEGACY, instruction: 0xF265,
+    before: State{PC: 0x202, I: 0x300},
+    memory: map[uint16]byte{0x300: 1, 0x301: 2, 0x302: 3, 0x303: 4},
+    after: func(s *State) { s.V = v(1, 2, 3); s.I = 0x303 }},
+}
+
+func copyState(s State) State {
+  s.Stack = append([]uint16{}, s.Stack...)
+  return s
+}
+
+func TestInstructions(t *testing.T) {
+  for _, test := range instructionTests {
+    for _, quirks := range test.modes {
+      c8 := NewChip8WithState(quirks, test.before)
+      for address, value := range test.memory {
+        c8.Memory()[address] = value
+      }
+      inst := utils.InstructionFromBytecode(test.instruction)
+      if err := c8.executeInstruction(&inst); err != nil {
+        t.Errorf("%s (%+v, %04X): %v", test.name, quirks, test.instruction, err)
+        continue
+      }
+
+      want := copyState(test.before)
+      test.after(&want)
+      if got := c8.State(); !reflect.DeepEqual(copyState(got), want) {
+        t.Errorf("%s (%+v, %04X):\n got %+v\nwant %+v", test.name, quirks, test.instruction, got, want)
+      }
+      for address, value := range test.wantMemory {
+        if c8.Memory()[address] != value {
+          t.Errorf("%s (%+v, %04X): memory[%X] = %d, want %d", test.name, quirks, test.instruction, address, c8.Memory()[address], value)
+        }
+      }
+    }
+  }
+}
+
+func TestClear(t *testing.T) {
+  c8 := NewChip8WithState(MODERN_QUIRKS, State{PC: 0x202})
+  c8.Display[0] = 1
+  c8.Display[len(c8.Display)-1] = 1
+  inst := utils.InstructionFromBytecode(0x00E0)
+  if err := c8.executeInstruction(&inst); err != nil {
+    t.Fatal(err)
+  }
+  if c8.Display != [DISPLAY_HEIGHT*DISPLAY_WIDTH]uint8{} {
+    t.Error("00E0 didn't clear the display")
+  }
+}
+
+func TestSprite(t *testing.T) {
+  tests := []struct {
+    name string
+    x uint8
+    y uint8
+    // pixels lit before drawing
+    lit []int
+    wantLit []int
+    wantVF uint8
+  }{
+    {"draws", 2, 1, nil, []int{1*DISPLAY_WIDTH + 2, 1*DISPLAY_WIDTH + 9, 2*DISPLAY_WIDTH + 3}, 0},
+    {"collision erases and sets VF", 2, 1, []int{1*DISPLAY_WIDTH + 2}, []int{1*DISPLAY_WIDTH + 9, 2*DISPLAY_WIDTH + 3}, 1},
+    {"start position wraps", 2 + DISPLAY_WIDTH, 1 + DISPLAY_HEIGHT, nil, []int{1*DISPLAY_WIDTH + 2, 1*DISPLAY_WIDTH + 9, 2*DISPLAY_WIDTH + 3}, 0},
+    {"clips at the right edge", DISPLAY_WIDTH - 2, 0, nil, []int{DISPLAY_WIDTH - 2, 1*DISPLAY_WIDTH + DISPLAY_WIDTH - 1}, 0},
+    {"clips at the bottom edge", 0, DISPLAY_HEIGHT - 1, nil, []int{(DISPLAY_HEIGHT-1)*DISPLAY_WIDTH, (DISPLAY_HEIGHT-1)*DISPLAY_WIDTH + 7}, 0},
+  }
+  for _, test := range tests {
+    c8 := NewChip8WithState(MODERN_QUIRKS, State{PC: 0x202, I: 0x300, V: v(0, test.x, test.y)})
+    // two rows: 10000001, 01000000
+    c8.Memory()[0x300] = 0x81
+    c8.Memory()[0x301] = 0x40
+    for _, index := range test.lit {
+      c8.Display[index] = 1
+    }
+    inst := utils.InstructionFromBytecode(0xD122)
+    if err := c8.executeInstruction(&inst); err != nil {
+      t.Fatal(err)
+    }
+
+    want := [DISPLAY_HEIGHT*DISPLAY_WIDTH]uint8{}
+    for _, index := range test.wantLit {
+      want[index] = 1
+    }
+    if c8.Display != want {
+      t.Errorf("%s: display differs", test.name)
+    }
+    if vf := c8.State().V[0xF]; vf != test.wantVF {
+      t.Errorf("%s: VF = %d, want %d", test.name, vf, test.wantVF)
+    }
+  }
+}
+
+func TestKeys(t *testing.T) {
+  tests := []struct {
+    name string
+    instruction uint16
+    pressed bool
+    wantPC uint16
+  }{
+    {"skipkey pressed skips", 0xE19E, true, 0x204},
+    {"skipkey released doesn't skip", 0xE19E, false, 0x202},
+    {"skipnkey released skips", 0xE1A1, false, 0x204},
+    {"skipnkey pressed doesn't skip", 0xE1A1, true, 0x202},
+  }
+  for _, test := range tests {
+    c8 := NewChip8WithState(MODERN_QUIRKS, State{PC: 0x202, V: v(0, 0xB)})
+    c8.SetKey(0xB, test.pressed)
+    inst := utils.InstructionFromBytecode(test.instruction)
+    if err := c8.executeInstruction(&inst); err != nil {
+      t.Fatal(err)
+    }
+    if pc := c8.State().PC; pc != test.wantPC {
+      t.Errorf("%s: pc = %X, want %X", test.name, pc, test.wantPC)
+    }
+  }
+}
+
+func TestWaitForKey(t *testing.T) {
+  c8 := NewChip8(false, MODERN_QUIRKS)
+  copy(c8.Memory()[PROGRAM_START:], []byte{0xF3, 0x0A})
+  fake := &fakeFrontEnd{}
+  c8.Attach(FrontEnd{Audio: fake})
+  step := func() {
+    if err := c8.Step(); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  step()
+  step()
+  if !c8.WaitingForKey() || c8.State().PC != 0x202 {
+    t.Fatalf("with no key pressed, waiting = %v and pc = %X, want waiting at 202", c8.WaitingForKey(), c8.State().PC)
+  }
+
+  c8.SetKey(0x7, true)
+  step()
+  c8.Refresh()
+  if !c8.WaitingForKey() || !fake.tone {
+    t.Error("while key 7 is held FX0A should keep waiting, and beep")
+  }
+
+  c8.SetKey(0x7, false)
+  step()
+  c8.Refresh()
+  if state := c8.State(); c8.WaitingForKey() || state.V[3] != 0x7 || fake.tone {
+    t.Errorf("after key 7 is let go, waiting = %v, V3 = %X and tone = %v, want done with 7 and quiet", c8.WaitingForKey(), state.V[3], fake.tone)
+  }
+}
+
+func TestWaitForKeyPress(t *testing.T) {
+  quirks := MODERN_QUIRKS
+  quirks.KeyPress = true
+  c8 := NewChip8WithState(quirks, State{PC: PROGRAM_START, DelayTimer: 10})
+  copy(c8.Memory()[PROGRAM_START:], []byte{0xF3, 0x0A})
+  if err := c8.RunFrame(); err != nil {
+    t.Fatal(err)
+  }
+  if !c8.WaitingForKey() || c8.State().DelayTimer != 9 {
+    t.Errorf("waiting = %v and delay timer = %d, want the timers to keep running while waiting", c8.WaitingForKey(), c8.State().DelayTimer)
+  }
+
+  c8.SetKey(0xB, true)
+  if err := c8.Step(); err != nil {
+    t.Fatal(err)
+  }
+  if c8.WaitingForKey() || c8.State().V[3] != 0xB {
+    t.Errorf("with the KeyPress quirk, FX0A should finish as soon as B is pressed")
+  }
+}
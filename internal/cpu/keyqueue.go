@@ -0,0 +1,66 @@
+package cpu
+
+import (
+  "sync"
+)
+
+// a key going down or up
+type KeyEvent struct {
+  Key uint8
+  Pressed bool
+  // the frame the core was on when the event happened
+  Frame uint64
+}
+
+// An InputSource that hands over every key edge rather than just which keys
+// are down, so RunFrame can apply them one frame at a time; see pollInput.
+type EventSource interface {
+  InputSource
+  // the events since the last call, oldest first; frame is the one about to run
+  KeyEvents(frame uint64) []KeyEvent
+}
+
+// A queue of key events for front ends whose input arrives on another
+// goroutine, e.g. ebiten's Update. Push as keys change and attach it as the
+// Input; the core takes the events at the start of each frame.
+type KeyQueue struct {
+  mu sync.Mutex
+  events []KeyEvent
+  // the frame the core last asked for events on
+  frame uint64
+  // every key's state after all the events pushed so far
+  keys [16]bool
+}
+
+// records an edge; repeats of a key's current state are ignored
+func (q *KeyQueue) Push(key uint8, pressed bool) {
+  q.mu.Lock()
+  defer q.mu.Unlock()
+  if q.keys[key] == pressed {
+    return
+  }
+  q.keys[key] = pressed
+  q.events = append(q.events, KeyEvent{key, pressed, q.frame})
+}
+
+// pushes an edge for every key in keys that changed, for front ends that poll
+func (q *KeyQueue) Set(keys [16]bool) {
+  for key, pressed := range keys {
+    q.Push(uint8(key), pressed)
+  }
+}
+
+func (q *KeyQueue) KeyEvents(frame uint64) []KeyEvent {
+  q.mu.Lock()
+  defer q.mu.Unlock()
+  q.frame = frame
+  events := q.events
+  q.events = nil
+  return events
+}
+
+func (q *KeyQueue) PollKeys(keys *[16]bool) {
+  q.mu.Lock()
+  defer q.mu.Unlock()
+  *keys = q.keys
+}
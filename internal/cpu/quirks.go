@@ -0,0 +1,31 @@
+package cpu
+
+// some instructions have slightly different implementations depending on
+// version/spec, and ROMs are written against one or the other. Each field
+// flips one instruction between interpretations.
+type Quirks struct {
+  // 8XY1, 8XY2, 8XY3 reset VF to 0 (COSMAC VIP)
+  ResetVF bool
+  // 8XY6, 8XYE shift VY into VX instead of shifting VX in place (COSMAC VIP)
+  ShiftVY bool
+  // BNNN jumps to XNN + VX instead of NNN + V0 (SUPER-CHIP)
+  JumpVX bool
+  // FX55, FX65 leave I pointing after the last register they touched (COSMAC VIP)
+  IncrementI bool
+  // FX0A finishes as soon as a key goes down, instead of when it's let go (CHIP-48, SUPER-CHIP)
+  KeyPress bool
+}
+
+// what most ROMs written since the 90s expect
+var MODERN_QUIRKS = Quirks{JumpVX: true}
+
+// the original COSMAC VIP interpreter
+var LEGACY_QUIRKS = Quirks{ResetVF: true, ShiftVY: true, IncrementI: true}
+
+// for the -modern flag
+func QuirksFor(modern bool) Quirks {
+  if modern {
+    return MODERN_QUIRKS
+  }
+  return LEGACY_QUIRKS
+}
@@ -0,0 +1,352 @@
+package cpu
+
+import (
+  "errors"
+  "fmt"
+  "math/rand"
+  "testing"
+
+  "jfeintzeig/chip8/internal/utils"
+)
+
+// A deliberately simple model of CHIP-8, written from the spec rather than
+// from instructions.go: every instruction is a pure function from one machine
+// state to the next. TestDifferential runs random programs on it and on Chip8
+// and reports the first place they disagree.
+
+type refMachine struct {
+  pc uint16
+  i uint16
+  v [16]uint8
+  stack []uint16
+  delay uint8
+  sound uint8
+  memory [MEMORY_SIZE]byte
+  display [DISPLAY_HEIGHT*DISPLAY_WIDTH]uint8
+}
+
+var errRefInvalid = errors.New("invalid instruction")
+
+// executes opcode as if it had just been fetched. random is the byte CXNN
+// draws, and keys are the keypad, since a pure function can't look at either.
+func refStep(m refMachine, opcode uint16, quirks Quirks, random uint8, keys [16]bool) (refMachine, error) {
+  m.stack = append([]uint16{}, m.stack...)
+  m.pc += 2
+
+  x := (opcode >> 8) & 0xF
+  y := (opcode >> 4) & 0xF
+  n := opcode & 0xF
+  nn := uint8(opcode)
+  nnn := opcode & 0xFFF
+  vx := m.v[x]
+  vy := m.v[y]
+  skip := func(condition bool) {
+    if condition {
+      m.pc += 2
+    }
+  }
+
+  switch {
+  case opcode == 0x00E0:
+    m.display = [DISPLAY_HEIGHT*DISPLAY_WIDTH]uint8{}
+  case opcode == 0x00EE:
+    if len(m.stack) == 0 {
+      return m, errRefInvalid
+    }
+    m.pc = m.stack[len(m.stack)-1]
+    m.stack = m.stack[:len(m.stack)-1]
+  case opcode >> 12 == 0x1:
+    m.pc = nnn
+  case opcode >> 12 == 0x2:
+    if len(m.stack) == utils.STACK_SIZE {
+      return m, errRefInvalid
+    }
+    m.stack = append(m.stack, m.pc)
+    m.pc = nnn
+  case opcode >> 12 == 0x3:
+    skip(vx == nn)
+  case opcode >> 12 == 0x4:
+    skip(vx != nn)
+  case opcode >> 12 == 0x5:
+    skip(vx == vy)
+  case opcode >> 12 == 0x9:
+    skip(vx != vy)
+  case opcode >> 12 == 0x6:
+    m.v[x] = nn
+  case opcode >> 12 == 0x7:
+    m.v[x] = vx + nn
+  case opcode >> 12 == 0x8:
+    var result, flag uint8
+    setsFlag := true
+    switch n {
+    case 0x0:
+      result, setsFlag = vy, false
+    case 0x1, 0x2, 0x3:
+      switch n {
+      case 0x1:
+        result = vx | vy
+      case 0x2:
+        result = vx & vy
+      case 0x3:
+        result = vx ^ vy
+      }
+      setsFlag = quirks.ResetVF
+    case 0x4:
+      sum := int(vx) + int(vy)
+      result = uint8(sum)
+      if sum > 0xFF {
+        flag = 1
+      }
+    case 0x5:
+      result = vx - vy
+      if vx >= vy {
+        flag = 1
+      }
+    case 0x7:
+      result = vy - vx
+      if vy >= vx {
+        flag = 1
+      }
+    case 0x6, 0xE:
+      source := vx
+      if quirks.ShiftVY {
+        source = vy
+      }
+      if n == 0x6 {
+        result, flag = source >> 1, source & 1
+      } else {
+        result, flag = source << 1, source >> 7
+      }
+    default:
+      return m, errRefInvalid
+    }
+    // the flag is written last, so it wins when X is F
+    m.v[x] = result
+    if setsFlag {
+      m.v[0xF] = flag
+    }
+  case opcode >> 12 == 0xA:
+    m.i = nnn
+  case opcode >> 12 == 0xB:
+    if quirks.JumpVX {
+      m.pc = nnn + uint16(vx)
+    } else {
+      m.pc = nnn + uint16(m.v[0])
+    }
+  case opcode >> 12 == 0xC:
+    m.v[x] = random & nn
+  case opcode >> 12 == 0xD:
+    column := int(vx) % DISPLAY_WIDTH
+    row := int(vy) % DISPLAY_HEIGHT
+    m.v[0xF] = 0
+    for line := 0; line < int(n) && row + line < DISPLAY_HEIGHT; line++ {
+      sprite := m.memory[(m.i + uint16(line)) % MEMORY_SIZE]
+      for bit := 0; bit < 8 && column + bit < DISPLAY_WIDTH; bit++ {
+        if sprite & (0x80 >> bit) == 0 {
+          continue
+        }
+        index := (row + line)*DISPLAY_WIDTH + column + bit
+        if m.display[index] == 1 {
+          m.v[0xF] = 1
+        }
+        m.display[index] ^= 1
+      }
+    }
+  case opcode & 0xF0FF == 0xE09E:
+    if vx > 0xF {
+      return m, errRefInvalid
+    }
+    skip(keys[vx])
+  case opcode & 0xF0FF == 0xE0A1:
+    if vx > 0xF {
+      return m, errRefInvalid
+    }
+    skip(!keys[vx])
+  case opcode & 0xF0FF == 0xF007:
+    m.v[x] = m.delay
+  case opcode & 0xF0FF == 0xF00A:
+    pressed := -1
+    for key, down := range keys {
+      if down {
+        pressed = key
+      }
+    }
+    // with no key down, the machine waits, which the harness stops at
+    if pressed >= 0 {
+      m.v[x] = uint8(pressed)
+    }
+  case opcode & 0xF0FF == 0xF015:
+    m.delay = vx
+  case opcode & 0xF0FF == 0xF018:
+    m.sound = vx
+  case opcode & 0xF0FF == 0xF01E:
+    if int(m.i) + int(vx) > 0xFFF {
+      m.v[0xF] = 1
+    }
+    m.i += uint16(vx)
+  case opcode & 0xF0FF == 0xF029:
+    m.i = FONT_START + 5*uint16(vx & 0xF)
+  case opcode & 0xF0FF == 0xF033:
+    m.memory[m.i % MEMORY_SIZE] = vx / 100
+    m.memory[(m.i+1) % MEMORY_SIZE] = vx / 10 % 10
+    m.memory[(m.i+2) % MEMORY_SIZE] = vx % 10
+  case opcode & 0xF0FF == 0xF055:
+    for r := uint16(0); r <= x; r++ {
+      m.memory[(m.i+r) % MEMORY_SIZE] = m.v[r]
+    }
+    if quirks.IncrementI {
+      m.i += x + 1
+    }
+  case opcode & 0xF0FF == 0xF065:
+    for r := uint16(0); r <= x; r++ {
+      m.v[r] = m.memory[(m.i+r) % MEMORY_SIZE]
+    }
+    if quirks.IncrementI {
+      m.i += x + 1
+    }
+  default:
+    return m, errRefInvalid
+  }
+  return m, nil
+}
+
+// opcodes worth generating, with X, Y, N and NN filled in at random.
+// 0NNN machine code calls aren't in here, no interpreter runs them.
+var refTemplates = []uint16{
+  0x00E0, 0x00EE, 0x1000, 0x2000, 0x3000, 0x4000, 0x5000, 0x9000, 0x6000, 0x7000,
+  0x8000, 0x8001, 0x8002, 0x8003, 0x8004, 0x8005, 0x8006, 0x8007, 0x800E, 0x8008,
+  0xA000, 0xB000, 0xC000, 0xD000, 0xE09E, 0xE0A1, 0xE0FF,
+  0xF007, 0xF00A, 0xF015, 0xF018, 0xF01E, 0xF029, 0xF033, 0xF055, 0xF065, 0xF0FF,
+}
+
+func randomOpcode(r *rand.Rand) uint16 {
+  template := refTemplates[r.Intn(len(refTemplates))]
+  switch template >> 12 {
+  case 0x0:
+    return template
+  case 0x1, 0x2, 0xA, 0xB:
+    return template | uint16(r.Intn(0x1000))
+  case 0x3, 0x4, 0x6, 0x7, 0xC:
+    return template | uint16(r.Intn(0x1000))
+  case 0x5, 0x9:
+    return template | uint16(r.Intn(0x100)) << 4
+  case 0x8:
+    return template | uint16(r.Intn(0x100)) << 4
+  case 0xD:
+    return template | uint16(r.Intn(0x1000))
+  default:
+    // E and F: only X varies
+    return template | uint16(r.Intn(0x10)) << 8
+  }
+}
+
+func randomMachine(r *rand.Rand) refMachine {
+  m := refMachine{pc: PROGRAM_START, i: uint16(r.Intn(MEMORY_SIZE))}
+  r.Read(m.v[:])
+  r.Read(m.memory[:])
+  for index := range m.display {
+    m.display[index] = uint8(r.Intn(2))
+  }
+  for depth := r.Intn(4); depth > 0; depth-- {
+    m.stack = append(m.stack, uint16(r.Intn(0x1000)))
+  }
+  m.delay = uint8(r.Intn(256))
+  m.sound = uint8(r.Intn(256))
+  return m
+}
+
+func newChip8FromRef(m refMachine, quirks Quirks) *Chip8 {
+  c8 := NewChip8WithState(quirks, State{m.pc, m.i, m.v, m.stack, m.delay, m.sound})
+  copy(c8.Memory(), m.memory[:])
+  c8.Display = m.display
+  return c8
+}
+
+// describes the first register, memory or display cell where c8 and m disagree, or ""
+func firstDivergence(c8 *Chip8, m refMachine) string {
+  state := c8.State()
+  if state.PC != m.pc {
+    return fmt.Sprintf("PC: chip8 %03X, reference %03X", state.PC, m.pc)
+  }
+  if state.I != m.i {
+    return fmt.Sprintf("I: chip8 %03X, reference %03X", state.I, m.i)
+  }
+  for r := range m.v {
+    if state.V[r] != m.v[r] {
+      return fmt.Sprintf("V%X: chip8 %02X, reference %02X", r, state.V[r], m.v[r])
+    }
+  }
+  if fmt.Sprint(state.Stack) != fmt.Sprint(m.stack) {
+    return fmt.Sprintf("stack: chip8 %X, reference %X", state.Stack, m.stack)
+  }
+  if state.DelayTimer != m.delay || state.SoundTimer != m.sound {
+    return fmt.Sprintf("timers: chip8 %d/%d, reference %d/%d", state.DelayTimer, state.SoundTimer, m.delay, m.sound)
+  }
+  for address, value := range c8.Memory() {
+    if value != m.memory[address] {
+      return fmt.Sprintf("memory[%03X]: chip8 %02X, reference %02X", address, value, m.memory[address])
+    }
+  }
+  for index, value := range c8.Display {
+    if value != m.display[index] {
+      return fmt.Sprintf("display (%d, %d): chip8 %d, reference %d", index % DISPLAY_WIDTH, index / DISPLAY_WIDTH, value, m.display[index])
+    }
+  }
+  return ""
+}
+
+func TestDifferential(t *testing.T) {
+  const TRIALS = 200
+  const SEQUENCE_LENGTH = 100
+  presets := map[string]Quirks{"modern": MODERN_QUIRKS, "legacy": LEGACY_QUIRKS}
+  for name, quirks := range presets {
+    // the keys never change here, so FX0A can only finish on a press;
+    // waiting for release across frames is covered by TestWaitForKey
+    quirks.KeyPress = true
+    presets[name] = quirks
+  }
+
+  for name, quirks := range presets {
+    r := rand.New(rand.NewSource(1))
+    for trial := 0; trial < TRIALS; trial++ {
+      m := randomMachine(r)
+      c8 := newChip8FromRef(m, quirks)
+      keys := [16]bool{}
+      for key := range keys {
+        keys[key] = r.Intn(4) == 0
+        c8.SetKey(uint8(key), keys[key])
+      }
+
+      history := []uint16{}
+      for step := 0; step < SEQUENCE_LENGTH; step++ {
+        opcode := randomOpcode(r)
+        history = append(history, opcode)
+
+        c8.incrementPC()
+        inst := utils.InstructionFromBytecode(opcode)
+        chip8Err := c8.executeInstruction(&inst)
+        // CXNN is random, so let the reference draw whatever Chip8 drew,
+        // after checking that it's been masked properly
+        random := c8.State().V[inst.X]
+        if inst.A == 0xC && random & ^inst.NN != 0 {
+          t.Fatalf("%s trial %d: %04X put %02X in V%X", name, trial, opcode, random, inst.X)
+        }
+        var refErr error
+        m, refErr = refStep(m, opcode, quirks, random, keys)
+
+        if (chip8Err != nil) != (refErr != nil) {
+          t.Fatalf("%s trial %d: after %04X, chip8 error %v, reference error %v", name, trial, history, chip8Err, refErr)
+        }
+        if chip8Err != nil {
+          break
+        }
+        if divergence := firstDivergence(c8, m); divergence != "" {
+          t.Fatalf("%s trial %d: after %04X, %s", name, trial, history, divergence)
+        }
+        if c8.WaitingForKey() {
+          break
+        }
+      }
+    }
+  }
+}
@@ -0,0 +1,193 @@
+package cpu
+
+import (
+  "archive/zip"
+  "errors"
+  "fmt"
+  "io"
+  "os"
+  "path"
+  "path/filepath"
+  "strings"
+)
+
+// XO-CHIP's 16 bit address space
+const XO_CHIP_MEMORY_SIZE = 0x10000
+
+// which machine a ROM was written for, which decides how much memory there
+// is and so how big a ROM can be
+type Platform int
+
+const (
+  CHIP_8 Platform = iota
+  XO_CHIP
+)
+
+var PLATFORM_NAMES = []string{"chip8", "xochip"}
+
+func ParsePlatform(name string) (Platform, error) {
+  for index, candidate := range PLATFORM_NAMES {
+    if candidate == name {
+      return Platform(index), nil
+    }
+  }
+  return CHIP_8, fmt.Errorf("unknown platform %q, want one of %s", name, strings.Join(PLATFORM_NAMES, ", "))
+}
+
+func (p Platform) String() string {
+  if p < 0 || int(p) >= len(PLATFORM_NAMES) {
+    return fmt.Sprintf("Platform(%d)", p)
+  }
+  return PLATFORM_NAMES[p]
+}
+
+func (p Platform) MemorySize() int {
+  if p == XO_CHIP {
+    return XO_CHIP_MEMORY_SIZE
+  }
+  return MEMORY_SIZE
+}
+
+// everything from PROGRAM_START to the end of memory, 3584 bytes on CHIP-8
+func (p Platform) MaxROMSize() int {
+  return p.MemorySize() - int(PROGRAM_START)
+}
+
+// file extensions picked out of zip archives
+var ROM_EXTENSIONS = []string{".ch8", ".c8", ".sc8", ".xo8"}
+
+// switches the memory size; call it before loading a ROM
+func (c8 *Chip8) SetPlatform(platform Platform) {
+  c8.platform = platform
+}
+
+func (c8 *Chip8) Platform() Platform {
+  return c8.platform
+}
+
+// copies program into memory at PROGRAM_START, clearing whatever was left
+// there by an earlier one
+func (c8 *Chip8) LoadBytes(program []byte) error {
+  if len(program) == 0 {
+    return errors.New("the ROM is empty")
+  }
+  if max := c8.platform.MaxROMSize(); len(program) > max {
+    return fmt.Errorf("the ROM is %d bytes, %s only has room for %d", len(program), c8.platform, max)
+  }
+  memory := c8.Memory()[PROGRAM_START:]
+  for index := range memory {
+    memory[index] = 0
+  }
+  copy(memory, program)
+  c8.programSize = uint16(len(program))
+  return nil
+}
+
+// reads a ROM to the end, without reading more than fits
+func (c8 *Chip8) LoadROM(r io.Reader) error {
+  program, err := io.ReadAll(io.LimitReader(r, int64(c8.platform.MaxROMSize()) + 1))
+  if err != nil {
+    return err
+  }
+  if max := c8.platform.MaxROMSize(); len(program) > max {
+    return fmt.Errorf("the ROM is over %d bytes, all %s has room for", max, c8.platform)
+  }
+  return c8.LoadBytes(program)
+}
+
+// loads the ROM at a path, see ReadROM
+func (c8 *Chip8) LoadFile(filePath string) error {
+  program, err := ReadROM(filePath)
+  if err != nil {
+    return err
+  }
+  return c8.LoadBytes(program)
+}
+
+// The bytes of a ROM file. "-" reads stdin. A path through a .zip, e.g.
+// games.zip/pong.ch8, reads that file from the archive; just games.zip reads
+// its only file, or its only file with one of ROM_EXTENSIONS.
+func ReadROM(filePath string) ([]byte, error) {
+  if filePath == "-" {
+    return readLimited(os.Stdin)
+  }
+  slashed := filepath.ToSlash(filePath)
+  if index := strings.Index(strings.ToLower(slashed), ".zip/"); index >= 0 {
+    return readZip(filepath.FromSlash(slashed[:index+len(".zip")]), slashed[index+len(".zip/"):])
+  }
+  if strings.EqualFold(filepath.Ext(filePath), ".zip") {
+    return readZip(filePath, "")
+  }
+  return os.ReadFile(filePath)
+}
+
+// reads r to the end, but gives up past XO_CHIP_MEMORY_SIZE rather than
+// reading a huge pipe or zip bomb into memory
+func readLimited(r io.Reader) ([]byte, error) {
+  data, err := io.ReadAll(io.LimitReader(r, XO_CHIP_MEMORY_SIZE + 1))
+  if err != nil {
+    return nil, err
+  }
+  if len(data) > XO_CHIP_MEMORY_SIZE {
+    return nil, fmt.Errorf("the ROM is over %d bytes, more than any platform has room for", XO_CHIP_MEMORY_SIZE)
+  }
+  return data, nil
+}
+
+// reads name from the archive, or picks the ROM if name is empty
+func readZip(archivePath string, name string) ([]byte, error) {
+  archive, err := zip.OpenReader(archivePath)
+  if err != nil {
+    return nil, err
+  }
+  defer archive.Close()
+
+  var chosen *zip.File
+  if name != "" {
+    for _, f := range archive.File {
+      if f.Name == name {
+        chosen = f
+      }
+    }
+    if chosen == nil {
+      return nil, fmt.Errorf("%s: no %s in the archive", archivePath, name)
+    }
+  } else {
+    files, roms := []*zip.File{}, []*zip.File{}
+    for _, f := range archive.File {
+      if f.FileInfo().IsDir() {
+        continue
+      }
+      files = append(files, f)
+      for _, extension := range ROM_EXTENSIONS {
+        if strings.EqualFold(path.Ext(f.Name), extension) {
+          roms = append(roms, f)
+        }
+      }
+    }
+    switch {
+    case len(files) == 0:
+      return nil, fmt.Errorf("%s: the archive is empty", archivePath)
+    case len(files) == 1:
+      chosen = files[0]
+    case len(roms) == 1:
+      chosen = roms[0]
+    default:
+      names := make([]string, len(files))
+      for index, f := range files {
+        names[index] = f.Name
+      }
+      return nil, fmt.Errorf("%s: pick one of its files, e.g. %s/%s: %s", archivePath, archivePath, names[0], strings.Join(names, ", "))
+    }
+  }
+
+  if chosen.UncompressedSize64 > XO_CHIP_MEMORY_SIZE {
+    return nil, fmt.Errorf("%s: %s is %d bytes, more than any platform has room for", archivePath, chosen.Name, chosen.UncompressedSize64)
+  }
+  f, err := chosen.Open()
+  if err != nil {
+    return nil, err
+  }
+  defer f.Close()
+  return readLimited(f)
+}
@@ -0,0 +1,120 @@
+package cpu
+
+import (
+  "archive/zip"
+  "bytes"
+  "io"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+func TestLoadBytes(t *testing.T) {
+  c8 := NewChip8(false, MODERN_QUIRKS)
+  full := bytes.Repeat([]byte{0xAB}, 3584)
+  if err := c8.LoadBytes(full); err != nil {
+    t.Fatalf("a ROM filling memory should fit: %v", err)
+  }
+  if c8.Memory()[MEMORY_SIZE-1] != 0xAB || len(c8.Program()) != 3584 {
+    t.Error("the ROM didn't reach the end of memory")
+  }
+  if err := c8.LoadBytes(append(full, 0)); err == nil {
+    t.Error("a byte past the end of memory should be an error")
+  }
+  if err := c8.LoadBytes(nil); err == nil {
+    t.Error("an empty ROM should be an error")
+  }
+
+  // a smaller ROM doesn't keep the end of the bigger one
+  if err := c8.LoadBytes([]byte{0x12, 0x00}); err != nil {
+    t.Fatal(err)
+  }
+  if c8.Memory()[PROGRAM_START+2] != 0 || len(c8.Program()) != 2 {
+    t.Error("the last ROM was left in memory")
+  }
+
+  c8.SetPlatform(XO_CHIP)
+  if err := c8.LoadROM(bytes.NewReader(append(full, 0))); err != nil {
+    t.Errorf("XO-CHIP should have room for more: %v", err)
+  }
+  if err := c8.LoadROM(bytes.NewReader(make([]byte, XO_CHIP_MEMORY_SIZE))); err == nil {
+    t.Error("a ROM bigger than 64K should be an error")
+  }
+}
+
+func TestReadROMFromZip(t *testing.T) {
+  dir := t.TempDir()
+  write := func(name string, files map[string]string) string {
+    var buf bytes.Buffer
+    archive := zip.NewWriter(&buf)
+    for name, contents := range files {
+      f, err := archive.Create(name)
+      if err != nil {
+        t.Fatal(err)
+      }
+      f.Write([]byte(contents))
+    }
+    if err := archive.Close(); err != nil {
+      t.Fatal(err)
+    }
+    archivePath := filepath.Join(dir, name)
+    if err := os.WriteFile(archivePath, buf.Bytes(), 0644); err != nil {
+      t.Fatal(err)
+    }
+    return archivePath
+  }
+
+  one := write("one.zip", map[string]string{"readme.txt": "hi", "games/pong.ch8": "pong"})
+  if program, err := ReadROM(one); err != nil || string(program) != "pong" {
+    t.Errorf("got %q, %v; want the only ROM in the archive", program, err)
+  }
+  if program, err := ReadROM(one + "/readme.txt"); err != nil || string(program) != "hi" {
+    t.Errorf("got %q, %v; want the file named after the archive", program, err)
+  }
+  if _, err := ReadROM(one + "/missing.ch8"); err == nil {
+    t.Error("a file that isn't in the archive should be an error")
+  }
+
+  two := write("two.zip", map[string]string{"pong.ch8": "pong", "tetris.ch8": "tetris"})
+  if _, err := ReadROM(two); err == nil || !strings.Contains(err.Error(), "pong.ch8") {
+    t.Errorf("got %v, want an error listing the ROMs to pick from", err)
+  }
+  big := write("big.zip", map[string]string{"big.ch8": strings.Repeat("\x00", XO_CHIP_MEMORY_SIZE + 1)})
+  if _, err := ReadROM(big); err == nil {
+    t.Error("a file bigger than 64K in an archive should be an error")
+  }
+}
+
+func TestReadROMFromStdin(t *testing.T) {
+  stdin := os.Stdin
+  defer func() { os.Stdin = stdin }()
+  for _, size := range []int{4, XO_CHIP_MEMORY_SIZE + 1} {
+    f, err := os.CreateTemp(t.TempDir(), "stdin")
+    if err != nil {
+      t.Fatal(err)
+    }
+    if _, err := f.Write(make([]byte, size)); err != nil {
+      t.Fatal(err)
+    }
+    f.Seek(0, io.SeekStart)
+    os.Stdin = f
+    program, err := ReadROM("-")
+    f.Close()
+    if size <= XO_CHIP_MEMORY_SIZE && (err != nil || len(program) != size) {
+      t.Errorf("got %d bytes, %v; want %d", len(program), err, size)
+    }
+    if size > XO_CHIP_MEMORY_SIZE && err == nil {
+      t.Errorf("read %d bytes from stdin", len(program))
+    }
+  }
+}
+
+func TestPlatformString(t *testing.T) {
+  if name := XO_CHIP.String(); name != "xochip" {
+    t.Errorf("got %s", name)
+  }
+  if name := Platform(7).String(); name != "Platform(7)" {
+    t.Errorf("got %s for an unknown platform", name)
+  }
+}
@@ -22,27 +22,45 @@ func (dis *disassembler) Disassemble() {
   if err != nil {
     log.Fatal("can't find inputFile")
   }
-  br := bufio.NewReader(inputFile)
+  defer inputFile.Close()
 
   outputFile, err := os.Create(*dis.outputFile)
+  if err != nil {
+    log.Fatal(err)
+  }
   defer outputFile.Close()
 
+  if err := DisassembleTo(inputFile, outputFile); err != nil {
+    log.Fatal(err)
+  }
+}
+
+// writes one line of assembly per two bytes read from r, until r runs out.
+// A trailing odd byte is written as data.
+func DisassembleTo(r io.Reader, w io.Writer) error {
+  br := bufio.NewReader(r)
+  bw := bufio.NewWriter(w)
+
   for {
     buf := make([]byte, 2)
     _, err := io.ReadFull(br, buf)
-    if err != nil && !errors.Is(err, io.EOF) {
-        fmt.Println(err)
-        break
+    if errors.Is(err, io.ErrUnexpectedEOF) {
+      fmt.Fprintf(bw, "0x%02X # data\n", buf[0])
+      break
     }
-    if err != nil {
+    if errors.Is(err, io.EOF) {
         // end of inputFile
         break
     }
+    if err != nil {
+        return err
+    }
     inst := utils.InstructionFromBytecode((uint16(buf[0]) << 8) | uint16(buf[1]))
-    inst.Template.Execute(outputFile, inst)
-    outputFile.WriteString("\n")
-    outputFile.Sync()
+    if _, err := bw.WriteString(inst.ToString() + "\n"); err != nil {
+      return err
+    }
   }
+  return bw.Flush()
 }
 
 func (dis *disassembler) writeX(mnemonic string, inst *utils.Instruction) string {
@@ -0,0 +1,22 @@
+package disassembler
+
+import (
+  "bytes"
+  "strings"
+  "testing"
+)
+
+// every ROM disassembles to one line per instruction, plus one for an odd trailing byte
+func FuzzDisassembleTo(f *testing.F) {
+  f.Add([]byte{0x00, 0xE0, 0xA2, 0x2A, 0x60, 0x0C})
+  f.Add([]byte{0xFF})
+  f.Fuzz(func(t *testing.T, rom []byte) {
+    var out bytes.Buffer
+    if err := DisassembleTo(bytes.NewReader(rom), &out); err != nil {
+      t.Fatal(err)
+    }
+    if lines := strings.Count(out.String(), "\n"); lines != (len(rom) + 1) / 2 {
+      t.Errorf("got %d lines for %d bytes", lines, len(rom))
+    }
+  })
+}
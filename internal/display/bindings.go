@@ -0,0 +1,105 @@
+package display
+
+import (
+  "fmt"
+  "strings"
+
+  "github.com/hajimehoshi/ebiten/v2"
+  "github.com/hajimehoshi/ebiten/v2/ebitenutil"
+  "github.com/hajimehoshi/ebiten/v2/inpututil"
+  "jfeintzeig/chip8/internal/keymap"
+)
+
+// keys the emulator itself uses, which can't be bound to the keypad
+var reservedKeys = map[ebiten.Key]bool{
+  ebiten.KeyF2: true,
+  ebiten.KeyF3: true,
+  ebiten.KeyF4: true,
+  ebiten.KeyF5: true,
+  ebiten.KeyF10: true,
+  ebiten.KeyF11: true,
+  ebiten.KeyF12: true,
+  ebiten.KeyEscape: true,
+  ebiten.KeyTab: true,
+}
+
+// the ebiten keys for each Chip8 key
+func hostKeys(k keymap.Keymap) ([16][]ebiten.Key, error) {
+  keys := [16][]ebiten.Key{}
+  for key, names := range k {
+    for _, name := range names {
+      var hostKey ebiten.Key
+      if err := hostKey.UnmarshalText([]byte(name)); err != nil {
+        return keys, fmt.Errorf("can't bind Chip8 key %X to %q: %w", key, name, err)
+      }
+      keys[key] = append(keys[key], hostKey)
+    }
+  }
+  return keys, nil
+}
+
+// The F4 screen: walks through the keypad one key at a time, binding each
+// to the next key pressed. Tab keeps a key's binding, Esc gives up.
+type bindingScreen struct {
+  keymap keymap.Keymap
+  // index into keymap.KEYPAD of the key being bound
+  position int
+}
+
+func newBindingScreen(current keymap.Keymap) *bindingScreen {
+  b := &bindingScreen{}
+  for key := range current {
+    b.keymap[key] = append([]string{}, current[key]...)
+  }
+  return b
+}
+
+// handles this tick's key presses; done is set when every key has been
+// bound, and cancelled if Esc was pressed
+func (b *bindingScreen) update() (done bool, cancelled bool) {
+  if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+    return false, true
+  }
+  if inpututil.IsKeyJustPressed(ebiten.KeyTab) {
+    b.position++
+    return b.position == len(keymap.KEYPAD), false
+  }
+  for hostKey := ebiten.Key(0); hostKey <= ebiten.KeyMax; hostKey++ {
+    if reservedKeys[hostKey] || !inpututil.IsKeyJustPressed(hostKey) {
+      continue
+    }
+    name := hostKey.String()
+    // a host key only presses one Chip8 key
+    for key := range b.keymap {
+      kept := []string{}
+      for _, bound := range b.keymap[key] {
+        if bound != name {
+          kept = append(kept, bound)
+        }
+      }
+      b.keymap[key] = kept
+    }
+    b.keymap[keymap.KEYPAD[b.position]] = []string{name}
+    b.position++
+    return b.position == len(keymap.KEYPAD), false
+  }
+  return false, false
+}
+
+func (b *bindingScreen) draw(window *ebiten.Image) {
+  var sb strings.Builder
+  sb.WriteString("KEY BINDINGS\n\n")
+  fmt.Fprintf(&sb, "Press a key for Chip8 key %X\n", keymap.KEYPAD[b.position])
+  sb.WriteString("Tab keeps the current binding, Esc cancels\n\n")
+  for index, key := range keymap.KEYPAD {
+    marker := " "
+    if index == b.position {
+      marker = ">"
+    }
+    fmt.Fprintf(&sb, "%s%X %-14s", marker, key, b.keymap.Describe(key))
+    if index % 4 == 3 {
+      sb.WriteString("\n")
+    }
+  }
+  ebitenutil.DebugPrintAt(window, sb.String(), 8, 8)
+}
@@ -1,88 +1,431 @@
 package display
 
 import (
-  "image/color"
+  "fmt"
+  "log"
+  "math"
+  "path/filepath"
+  "strings"
+  "sync"
+  "time"
+
   "github.com/hajimehoshi/ebiten/v2/audio"
-  "github.com/hajimehoshi/ebiten/v2/audio/mp3"
   "github.com/hajimehoshi/ebiten/v2"
-  "github.com/hajimehoshi/ebiten/v2/ebitenutil"
   "github.com/hajimehoshi/ebiten/v2/inpututil"
   "jfeintzeig/chip8/internal/cpu"
+  "jfeintzeig/chip8/internal/keymap"
+  "jfeintzeig/chip8/internal/screen"
+  "jfeintzeig/chip8/internal/sound"
 )
 
-var (
-  pixel = ebiten.NewImage(10,10)
+// how far ahead the audio player buffers; the beep starts and stops this late at most
+const AUDIO_BUFFER = 50 * time.Millisecond
+
+// how the framebuffer is fitted into the window; either way it's centred
+// and the leftover space is letterboxed in black
+type ScaleMode int
+
+const (
+  // the largest whole number scale that fits, so every Chip8 pixel is the same size
+  INTEGER ScaleMode = iota
+  // as large as fits while keeping the aspect ratio
+  FIT
 )
 
-func init() {
-  pixel.Fill(color.White)
+type Options struct {
+  // initial window size, in screen pixels per Chip8 pixel
+  Scale int
+  Fullscreen bool
+  ScaleMode ScaleMode
+  // the ROM's file name, shown in the title bar unless there's a Title
+  Name string
+  // e.g. from the ROM database
+  Title string
+  // a name from screen.PALETTES or hex colours, see screen.ParsePalette
+  Palette string
+  // called when F2 switches palette, e.g. to remember it for this ROM
+  OnPaletteChange func(palette string)
+  // flicker reduction, see screen.Persistence
+  Persistence screen.PersistenceMode
+  Decay float64
+  PersistenceFrames int
+  // size of a Chip8 pixel in screenshots and recordings
+  CaptureScale int
+  // if set, start recording straight away and save here on StopRecording
+  Record string
+  // also record the sound to a .wav with the same name as each recording
+  RecordAudio bool
+  // host keys for the keypad, keymap.LAYOUTS["qwerty"] if empty
+  Keymap keymap.Keymap
+  // called when the F4 screen changes the bindings, e.g. to save them for this ROM
+  OnKeymapChange func(k keymap.Keymap)
+  // gamepad controls for the keypad, see keymap/gamepad.go; keymap.GAMEPAD_PROFILES["numeric"] if empty
+  GamepadMap keymap.Keymap
+  // how far a stick has to move, from 0 to 1, to press a key
+  DeadZone float64
+  // start with the on-screen keypad showing, see keypad.go; F5 toggles it
+  ShowKeypad bool
+  // the beep, see sound.Tone; F3 toggles Muted
+  Frequency float64
+  Waveform sound.Waveform
+  Volume float64
+  Muted bool
+}
+
+// "<rom name> - <speed> Hz"
+func Title(name string, clockSpeed uint16) string {
+  return fmt.Sprintf("%s - %d Hz", name, clockSpeed)
 }
 
 type Game struct {
   c8 *cpu.Chip8
-  keyboard [16]ebiten.Key
+  keyboard [16][]ebiten.Key
+  keyQueue cpu.KeyQueue
+  // the keys down as of the last Update, for the on-screen keypad
+  pressed [16]bool
+  // the window size from the last Layout
+  windowWidth int
+  windowHeight int
+  gamepads gamepads
+  gamepadControls [16][]gamepadControl
+  // non-nil while the F4 key binding screen is up
+  binding *bindingScreen
+  tone *sound.Tone
   audioPlayer *audio.Player
+  options Options
+  title string
+  palette screen.Palette
+  persistence *screen.Persistence
+  // the framebuffer at one texel per Chip8 pixel, rewritten every frame and
+  // scaled up in a single draw, and the RGBA bytes it's written from
+  framebuffer *ebiten.Image
+  pixels []byte
+  // where the F10 or -record recording is saved
+  recordPath string
+  // the core runs on its own goroutine and talks to us through the
+  // cpu.FrontEnd methods below, so what it hands over is guarded by mu
+  mu sync.Mutex
+  frame screen.Frame
+  // non-nil while recording; fed straight from the core, one frame of
+  // each per frame the core runs, so the video and audio line up
+  recorder *screen.Recorder
+  audioRecorder *sound.Renderer
+}
+
+// cpu.VideoSink; keeps a copy for Update to pick up
+func (g *Game) DrawFrame(pixels []uint8, width int, height int) {
+  g.mu.Lock()
+  defer g.mu.Unlock()
+  g.frame = screen.NewFrame(pixels, width, height)
+  if g.recorder != nil {
+    g.recorder.AddFrame(g.frame)
+  }
+}
+
+// cpu.AudioSink
+func (g *Game) SetTone(on bool) {
+  g.tone.SetTone(on)
+  g.mu.Lock()
+  defer g.mu.Unlock()
+  if g.audioRecorder != nil {
+    g.audioRecorder.SetTone(on)
+  }
+}
+
+// cpu.PatternSink
+func (g *Game) SetPattern(pattern [cpu.AUDIO_PATTERN_SIZE]byte, pitch uint8) {
+  g.tone.SetPattern(pattern, pitch)
+  g.mu.Lock()
+  defer g.mu.Unlock()
+  if g.audioRecorder != nil {
+    g.audioRecorder.SetPattern(pattern, pitch)
+  }
+}
+
+// sizes the window from the machine's resolution and sets the title; call before ebiten.RunGame
+func (g *Game) ConfigureWindow() {
+  width, height := g.c8.Resolution()
+  ebiten.SetWindowSize(width*g.options.Scale, height*g.options.Scale)
+  ebiten.SetWindowResizingMode(ebiten.WindowResizingModeEnabled)
+  ebiten.SetFullscreen(g.options.Fullscreen)
+  g.updateTitle()
+}
+
+func (g *Game) updateTitle() {
+  name := g.options.Title
+  if name == "" {
+    name = filepath.Base(g.options.Name)
+  }
+  if title := Title(name, g.c8.ClockSpeed()); title != g.title {
+    g.title = title
+    ebiten.SetWindowTitle(title)
+  }
 }
 
 func (g *Game) Update() error {
-  for index, key := range g.keyboard {
-    g.c8.Keyboard[index].Pressed = ebiten.IsKeyPressed(key)
-    g.c8.Keyboard[index].JustReleased = inpututil.IsKeyJustReleased(key)
+  if inpututil.IsKeyJustPressed(ebiten.KeyF11) {
+    ebiten.SetFullscreen(!ebiten.IsFullscreen())
   }
-  if g.c8.GetSoundTimer() > 0 {
-    g.audioPlayer.Play()
-    g.audioPlayer.Rewind()
+  g.updateTitle()
+  if g.binding == nil && inpututil.IsKeyJustPressed(ebiten.KeyF4) {
+    g.binding = newBindingScreen(g.options.Keymap)
+  } else if g.binding != nil {
+    if done, cancelled := g.binding.update(); done {
+      if err := g.SetKeymap(g.binding.keymap); err != nil {
+        log.Println(err)
+      } else if g.options.OnKeymapChange != nil {
+        g.options.OnKeymapChange(g.options.Keymap)
+      }
+      g.binding = nil
+    } else if cancelled {
+      g.binding = nil
+    }
   }
+  g.gamepads.update()
+  g.mu.Lock()
+  frame := g.frame
+  g.mu.Unlock()
+  if inpututil.IsKeyJustPressed(ebiten.KeyF5) {
+    g.options.ShowKeypad = !g.options.ShowKeypad
+  }
+  touches := ebiten.AppendTouchIDs(nil)
+  if len(touches) > 0 && !g.options.ShowKeypad {
+    // no keyboard on a touch screen, so the first touch brings up the keypad
+    g.options.ShowKeypad = true
+  }
+  keys := [16]bool{}
+  if g.options.ShowKeypad && g.binding == nil {
+    keys = keypadPointers(g.windowWidth, g.windowHeight)
+  }
+  for index, hostKeys := range g.keyboard {
+    // the keypad is released while keys are being bound
+    if g.binding != nil {
+      continue
+    }
+    for _, hostKey := range hostKeys {
+      keys[index] = keys[index] || ebiten.IsKeyPressed(hostKey)
+    }
+    keys[index] = keys[index] || g.gamepads.pressed(g.gamepadControls[index], g.options.DeadZone)
+  }
+  // queued rather than handed over as they are, so the core sees every
+  // press however briefly it's held, see cpu.KeyQueue
+  g.keyQueue.Set(keys)
+  g.pressed = keys
+  if inpututil.IsKeyJustPressed(ebiten.KeyF12) {
+    path := screen.CaptureName("png")
+    if err := screen.SaveScreenshot(path, frame, g.options.CaptureScale, g.palette); err != nil {
+      log.Println(err)
+    } else {
+      log.Printf("saved screenshot to %s", path)
+    }
+  }
+  if inpututil.IsKeyJustPressed(ebiten.KeyF10) {
+    if !g.isRecording() {
+      g.StartRecording(screen.CaptureName("gif"))
+    } else {
+      g.StopRecording()
+    }
+  }
+  if inpututil.IsKeyJustPressed(ebiten.KeyF3) {
+    if g.tone.ToggleMute() {
+      log.Println("sound muted, F3 to unmute")
+    } else {
+      log.Println("sound on")
+    }
+  }
+  if inpututil.IsKeyJustPressed(ebiten.KeyF2) {
+    g.SetPalette(screen.NextPalette(g.options.Palette))
+    if g.options.OnPaletteChange != nil {
+      g.options.OnPaletteChange(g.options.Palette)
+    }
+  }
+  // once per tick rather than in Draw, so the filter runs at 60Hz whatever the refresh rate
+  g.persistence.Update(frame)
   return nil
 }
 
-func (g *Game) Draw(screen *ebiten.Image) {
-  for index, element := range g.c8.Display {
-    if element == 1 {
-      op := &ebiten.DrawImageOptions{}
-      y := int(index / 64)
-      x := int(index % 64)
-      op.GeoM.Translate(float64(x*10),float64(y*10))
-      screen.DrawImage(pixel, op)
+func (g *Game) isRecording() bool {
+  g.mu.Lock()
+  defer g.mu.Unlock()
+  return g.recorder != nil
+}
+
+// the .wav that goes with a recording
+func audioPath(path string) string {
+  return strings.TrimSuffix(path, filepath.Ext(path)) + ".wav"
+}
+
+// records every frame from now on, in the current palette, until StopRecording
+func (g *Game) StartRecording(path string) {
+  g.mu.Lock()
+  defer g.mu.Unlock()
+  g.recorder = screen.NewRecorder(g.options.CaptureScale, g.palette)
+  if g.options.RecordAudio {
+    // a tone of its own, so capturing doesn't take samples from the one
+    // being played, and recordings aren't silent when muted
+    g.audioRecorder = sound.NewRenderer(sound.NewTone(g.options.Frequency, g.options.Waveform, g.options.Volume))
+  }
+  g.recordPath = path
+  log.Printf("recording to %s, F10 to stop", path)
+}
+
+// saves the recording, if there is one; call after ebiten.RunGame returns
+// so recordings still running when the window closes aren't lost
+func (g *Game) StopRecording() {
+  g.mu.Lock()
+  recorder, audioRecorder := g.recorder, g.audioRecorder
+  g.recorder, g.audioRecorder = nil, nil
+  g.mu.Unlock()
+  if recorder == nil {
+    return
+  }
+  if err := recorder.Save(g.recordPath); err != nil {
+    log.Println(err)
+  } else {
+    log.Printf("saved %d frame recording to %s", recorder.Ticks(), g.recordPath)
+  }
+  if audioRecorder != nil {
+    if err := audioRecorder.Save(audioPath(g.recordPath)); err != nil {
+      log.Println(err)
+    } else {
+      log.Printf("saved %d frames of audio to %s", audioRecorder.Frames(), audioPath(g.recordPath))
     }
   }
 }
 
+// stops the sound and saves any recording, for when the launcher is done with it
+func (g *Game) Close() {
+  g.StopRecording()
+  if err := g.audioPlayer.Close(); err != nil {
+    log.Println(err)
+  }
+}
+
+// size of a Chip8 pixel on screen, and where the top left corner of the
+// framebuffer goes so that it's centred
+func (g *Game) transform(screenWidth int, screenHeight int) (scale float64, offsetX float64, offsetY float64) {
+  width, height := g.c8.Resolution()
+  scale = math.Min(float64(screenWidth)/float64(width), float64(screenHeight)/float64(height))
+  if g.options.ScaleMode == INTEGER && scale >= 1 {
+    scale = math.Floor(scale)
+  }
+  offsetX = (float64(screenWidth) - scale*float64(width)) / 2
+  offsetY = (float64(screenHeight) - scale*float64(height)) / 2
+  return scale, offsetX, offsetY
+}
+
+func (g *Game) SetPalette(spec string) error {
+  palette, err := screen.ParsePalette(spec)
+  if err != nil {
+    return err
+  }
+  g.palette = palette
+  g.options.Palette = spec
+  return nil
+}
+
+func (g *Game) SetKeymap(k keymap.Keymap) error {
+  keyboard, err := hostKeys(k)
+  if err != nil {
+    return err
+  }
+  g.keyboard = keyboard
+  g.options.Keymap = k
+  return nil
+}
+
+func (g *Game) Draw(window *ebiten.Image) {
+  if g.binding != nil {
+    g.binding.draw(window)
+    return
+  }
+  width, height := g.c8.Resolution()
+  if g.framebuffer == nil || g.framebuffer.Bounds().Dx() != width || g.framebuffer.Bounds().Dy() != height {
+    g.framebuffer = ebiten.NewImage(width, height)
+    g.pixels = make([]byte, 4*width*height)
+  }
+  g.persistence.WriteRGBA(g.pixels, g.palette)
+  g.framebuffer.WritePixels(g.pixels)
+
+  // the window is cleared to black every frame, which letterboxes the framebuffer
+  scale, offsetX, offsetY := g.transform(window.Size())
+  op := &ebiten.DrawImageOptions{}
+  op.GeoM.Scale(scale, scale)
+  op.GeoM.Translate(offsetX, offsetY)
+  window.DrawImage(g.framebuffer, op)
+  if g.options.ShowKeypad {
+    drawKeypad(window, g.pressed, g.options.Keymap, g.palette.Color(1))
+  }
+}
+
+// we draw at the window's full resolution and do the scaling ourselves in Draw
 func (g *Game) Layout(outsideWidth, outsideHeight int) (screenWidth, screenHeight int) {
-  // TODO: abstract this away.
-  // rn layout and `pixel` are 10x actual memory in Chip8, need to codify this somewhere better...
-  return 640, 320
-}
-
-func NewGame(c8 *cpu.Chip8) (*Game, error) {
-  keyboard := [16]ebiten.Key{
-    ebiten.KeyX,
-    ebiten.KeyDigit1,
-    ebiten.KeyDigit2,
-    ebiten.KeyDigit3,
-    ebiten.KeyQ,
-    ebiten.KeyW,
-    ebiten.KeyE,
-    ebiten.KeyA,
-    ebiten.KeyS,
-    ebiten.KeyD,
-    ebiten.KeyZ,
-    ebiten.KeyC,
-    ebiten.KeyDigit4,
-    ebiten.KeyR,
-    ebiten.KeyF,
-    ebiten.KeyV,
-  }
-
-  audioContext := audio.NewContext(48000)
-  f, _ := ebitenutil.OpenFile("data/beep.mp3")
-  d, _ := mp3.Decode(audioContext, f)
-  audioPlayer, _ := audio.NewPlayer(audioContext, d)
+  g.windowWidth, g.windowHeight = outsideWidth, outsideHeight
+  return outsideWidth, outsideHeight
+}
+
+func NewGame(c8 *cpu.Chip8, options Options) (*Game, error) {
+  persistence, err := screen.NewPersistence(options.Persistence, options.Decay, options.PersistenceFrames)
+  if err != nil {
+    return nil, err
+  }
+
+  // the tone is silent until the sound timer starts, so it can play the whole time
+  tone := sound.NewTone(options.Frequency, options.Waveform, options.Volume)
+  tone.SetMuted(options.Muted)
+  // there's one per process, and the launcher makes a Game per ROM
+  audioContext := audio.CurrentContext()
+  if audioContext == nil {
+    audioContext = audio.NewContext(sound.SAMPLE_RATE)
+  }
+  audioPlayer, err := audio.NewPlayer(audioContext, tone)
+  if err != nil {
+    return nil, err
+  }
+  audioPlayer.SetBufferSize(AUDIO_BUFFER)
+  audioPlayer.Play()
+
+  if options.Scale < 1 {
+    options.Scale = 1
+  }
+  if options.Palette == "" {
+    options.Palette = screen.PALETTE_NAMES[0]
+  }
 
+  if options.DeadZone <= 0 {
+    options.DeadZone = keymap.DEFAULT_DEAD_ZONE
+  }
+  if options.CaptureScale < 1 {
+    options.CaptureScale = options.Scale
+  }
+
+  width, height := c8.Resolution()
   g := &Game{
-    c8,
-    keyboard,
-    audioPlayer,
+    c8: c8,
+    tone: tone,
+    audioPlayer: audioPlayer,
+    options: options,
+    frame: screen.NewFrame(make([]uint8, width*height), width, height),
+    persistence: persistence,
+  }
+  if err := g.SetPalette(options.Palette); err != nil {
+    return nil, err
+  }
+  if options.Keymap.Empty() {
+    options.Keymap, _ = keymap.Layout(keymap.DEFAULT_LAYOUT)
+  }
+  if err := g.SetKeymap(options.Keymap); err != nil {
+    return nil, err
+  }
+  if options.GamepadMap.Empty() {
+    options.GamepadMap, _ = keymap.GamepadProfile(keymap.DEFAULT_GAMEPAD_PROFILE)
+  }
+  if g.gamepadControls, err = gamepadControls(options.GamepadMap); err != nil {
+    return nil, err
+  }
+  if options.Record != "" {
+    g.StartRecording(options.Record)
   }
+  c8.Attach(cpu.FrontEnd{Video: g, Audio: g, Input: &g.keyQueue})
   return g, nil
 }
@@ -0,0 +1,207 @@
+package display
+
+import (
+  "errors"
+  "flag"
+  "image/color"
+  "os"
+  "testing"
+
+  "github.com/hajimehoshi/ebiten/v2"
+
+  "jfeintzeig/chip8/internal/cpu"
+  "jfeintzeig/chip8/internal/keymap"
+  "jfeintzeig/chip8/internal/screen"
+)
+
+// Benchmarks for Draw. These need a display, so they only open a window when
+// run with -bench, e.g. go test ./internal/display -run XXX -bench .
+
+var errTestsDone = errors.New("tests done")
+
+// ebiten only runs one game per process, on the main thread, so the
+// benchmarks run in a goroutine and hand their drawing over to it
+var drawRequests = make(chan func(*ebiten.Image))
+var drawDone = make(chan struct{})
+
+type testGame struct {
+  m *testing.M
+  code int
+  started bool
+  finished chan struct{}
+}
+
+func (g *testGame) Update() error {
+  if !g.started {
+    g.started = true
+    go func() {
+      g.code = g.m.Run()
+      close(g.finished)
+    }()
+  }
+  select {
+  case <-g.finished:
+    return errTestsDone
+  default:
+    return nil
+  }
+}
+
+func (g *testGame) Draw(window *ebiten.Image) {
+  select {
+  case draw := <-drawRequests:
+    draw(window)
+    drawDone <- struct{}{}
+  default:
+  }
+}
+
+func (g *testGame) Layout(outsideWidth, outsideHeight int) (int, int) {
+  return 640, 320
+}
+
+func TestMain(m *testing.M) {
+  flag.Parse()
+  if flag.Lookup("test.bench").Value.String() == "" {
+    os.Exit(m.Run())
+  }
+  ebiten.SetFPSMode(ebiten.FPSModeVsyncOffMaximum)
+  g := &testGame{m: m, finished: make(chan struct{})}
+  if err := ebiten.RunGame(g); err != nil && !errors.Is(err, errTestsDone) {
+    panic(err)
+  }
+  os.Exit(g.code)
+}
+
+// runs draw b.N times inside a single ebiten frame
+func benchmarkDraw(b *testing.B, draw func(*ebiten.Image)) {
+  drawRequests <- func(window *ebiten.Image) {
+    b.ResetTimer()
+    for n := 0; n < b.N; n++ {
+      draw(window)
+    }
+    b.StopTimer()
+  }
+  <-drawDone
+}
+
+// a Game with every pixel lit, the worst case for drawing pixel by pixel
+func newBenchmarkGame() *Game {
+  c8 := cpu.NewChip8(false, cpu.MODERN_QUIRKS)
+  for index := range c8.Display {
+    c8.Display[index] = 1
+  }
+  persistence, _ := screen.NewPersistence(screen.NO_PERSISTENCE, 0, 0)
+  g := &Game{
+    c8: c8,
+    options: Options{ScaleMode: INTEGER},
+    palette: screen.CLASSIC,
+    persistence: persistence,
+  }
+  width, height := c8.Resolution()
+  g.persistence.Update(screen.NewFrame(c8.Display[:], width, height))
+  return g
+}
+
+// how Draw used to work: one DrawImage call per lit pixel
+func drawPerPixel(g *Game, pixel *ebiten.Image, window *ebiten.Image) {
+  width, _ := g.c8.Resolution()
+  scale, offsetX, offsetY := g.transform(window.Size())
+  for index, element := range g.c8.Display {
+    if element != 0 {
+      op := &ebiten.DrawImageOptions{}
+      op.GeoM.Scale(scale, scale)
+      op.GeoM.Translate(offsetX + float64(index % width)*scale, offsetY + float64(index / width)*scale)
+      op.ColorM.ScaleWithColor(g.palette.Color(element))
+      window.DrawImage(pixel, op)
+    }
+  }
+}
+
+func BenchmarkDrawPerPixel(b *testing.B) {
+  g := newBenchmarkGame()
+  pixel := ebiten.NewImage(1, 1)
+  pixel.Fill(color.White)
+  benchmarkDraw(b, func(window *ebiten.Image) {
+    drawPerPixel(g, pixel, window)
+  })
+}
+
+func BenchmarkDrawTexture(b *testing.B) {
+  g := newBenchmarkGame()
+  benchmarkDraw(b, g.Draw)
+}
+
+func TestLayoutsAreEbitenKeys(t *testing.T) {
+  for _, name := range keymap.LAYOUT_NAMES {
+    k, _ := keymap.Layout(name)
+    if _, err := hostKeys(k); err != nil {
+      t.Errorf("%s: %v", name, err)
+    }
+  }
+}
+
+func TestGamepadControls(t *testing.T) {
+  for _, name := range keymap.GAMEPAD_PROFILE_NAMES {
+    k, _ := keymap.GamepadProfile(name)
+    if _, err := gamepadControls(k); err != nil {
+      t.Errorf("%s: %v", name, err)
+    }
+  }
+  for _, name := range []string{"Button12", "Axis3-", "Axis0+"} {
+    if _, err := parseGamepadControl(name); err != nil {
+      t.Error(err)
+    }
+  }
+  for _, name := range []string{"Button", "Axis2", "Trigger"} {
+    if _, err := parseGamepadControl(name); err == nil {
+      t.Errorf("%q should be unknown", name)
+    }
+  }
+}
+
+func TestKeypadKeyAt(t *testing.T) {
+  x, y, cell := keypadRect(640, 320)
+  at := func(column float64, row float64) int {
+    return keypadKeyAt(640, 320, int(x + cell*column), int(y + cell*row))
+  }
+  if key := at(0.5, 0.5); key != 0x1 {
+    t.Errorf("top left is %X, want 1", key)
+  }
+  if key := at(3.5, 3.5); key != 0xF {
+    t.Errorf("bottom right is %X, want F", key)
+  }
+  if key := at(1.5, 3.5); key != 0x0 {
+    t.Errorf("bottom row second is %X, want 0", key)
+  }
+  if key := at(-0.5, 0.5); key != -1 {
+    t.Errorf("left of the keypad is %X, want nothing", key)
+  }
+}
+
+func TestLauncherItemAt(t *testing.T) {
+  l := &Launcher{items: make([]LibraryItem, 10), windowWidth: 640, windowHeight: 320}
+  if rows := l.rows(); rows != 6 {
+    t.Errorf("got %d rows, want 6", rows)
+  }
+  tests := []struct {
+    x, y, want int
+  }{
+    {10, LAUNCHER_ROW_HEIGHT + 5, 0},
+    {10, 2*LAUNCHER_ROW_HEIGHT + 5, 1},
+    // the header, and the preview on the right
+    {10, 5, -1},
+    {400, LAUNCHER_ROW_HEIGHT + 5, -1},
+    // below the last row that fits
+    {10, 7*LAUNCHER_ROW_HEIGHT + 5, -1},
+  }
+  for _, test := range tests {
+    if got := l.itemAt(test.x, test.y); got != test.want {
+      t.Errorf("itemAt(%d, %d) = %d, want %d", test.x, test.y, got, test.want)
+    }
+  }
+  l.scroll = 3
+  if got := l.itemAt(10, LAUNCHER_ROW_HEIGHT + 5); got != 3 {
+    t.Errorf("scrolled by 3, got %d, want 3", got)
+  }
+}
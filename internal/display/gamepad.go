@@ -0,0 +1,157 @@
+package display
+
+import (
+  "fmt"
+  "log"
+  "strconv"
+  "strings"
+
+  "github.com/hajimehoshi/ebiten/v2"
+  "github.com/hajimehoshi/ebiten/v2/inpututil"
+  "jfeintzeig/chip8/internal/keymap"
+)
+
+// gamepad control names, see keymap/gamepad.go
+var standardButtons = map[string]ebiten.StandardGamepadButton{
+  "A": ebiten.StandardGamepadButtonRightBottom,
+  "B": ebiten.StandardGamepadButtonRightRight,
+  "X": ebiten.StandardGamepadButtonRightLeft,
+  "Y": ebiten.StandardGamepadButtonRightTop,
+  "L1": ebiten.StandardGamepadButtonFrontTopLeft,
+  "R1": ebiten.StandardGamepadButtonFrontTopRight,
+  "L2": ebiten.StandardGamepadButtonFrontBottomLeft,
+  "R2": ebiten.StandardGamepadButtonFrontBottomRight,
+  "Select": ebiten.StandardGamepadButtonCenterLeft,
+  "Start": ebiten.StandardGamepadButtonCenterRight,
+  "LeftStick": ebiten.StandardGamepadButtonLeftStick,
+  "RightStick": ebiten.StandardGamepadButtonRightStick,
+  "DPadUp": ebiten.StandardGamepadButtonLeftTop,
+  "DPadDown": ebiten.StandardGamepadButtonLeftBottom,
+  "DPadLeft": ebiten.StandardGamepadButtonLeftLeft,
+  "DPadRight": ebiten.StandardGamepadButtonLeftRight,
+  "Home": ebiten.StandardGamepadButtonCenterCenter,
+}
+
+type stickDirection struct {
+  axis ebiten.StandardGamepadAxis
+  // -1 for up and left, 1 for down and right
+  sign float64
+}
+
+var standardSticks = map[string]stickDirection{
+  "LeftStickUp": {ebiten.StandardGamepadAxisLeftStickVertical, -1},
+  "LeftStickDown": {ebiten.StandardGamepadAxisLeftStickVertical, 1},
+  "LeftStickLeft": {ebiten.StandardGamepadAxisLeftStickHorizontal, -1},
+  "LeftStickRight": {ebiten.StandardGamepadAxisLeftStickHorizontal, 1},
+  "RightStickUp": {ebiten.StandardGamepadAxisRightStickVertical, -1},
+  "RightStickDown": {ebiten.StandardGamepadAxisRightStickVertical, 1},
+  "RightStickLeft": {ebiten.StandardGamepadAxisRightStickHorizontal, -1},
+  "RightStickRight": {ebiten.StandardGamepadAxisRightStickHorizontal, 1},
+}
+
+// one named control, parsed once rather than every frame
+type gamepadControl struct {
+  // set for standard buttons and sticks
+  standardButton *ebiten.StandardGamepadButton
+  standardStick *stickDirection
+  // otherwise a raw button, or a raw axis in the direction of sign
+  button int
+  axis int
+  sign float64
+}
+
+func parseGamepadControl(name string) (gamepadControl, error) {
+  control := gamepadControl{button: -1, axis: -1}
+  if button, ok := standardButtons[name]; ok {
+    control.standardButton = &button
+    // pads without a known layout usually number their buttons the same way
+    control.button = int(button)
+    return control, nil
+  }
+  if stick, ok := standardSticks[name]; ok {
+    control.standardStick = &stick
+    // and their sticks as axes 0 to 3
+    control.axis = int(stick.axis)
+    control.sign = stick.sign
+    return control, nil
+  }
+  if number, err := strconv.Atoi(strings.TrimPrefix(name, "Button")); strings.HasPrefix(name, "Button") && err == nil && number >= 0 {
+    control.button = number
+    return control, nil
+  }
+  if strings.HasPrefix(name, "Axis") && (strings.HasSuffix(name, "+") || strings.HasSuffix(name, "-")) {
+    number, err := strconv.Atoi(name[len("Axis"):len(name)-1])
+    if err == nil && number >= 0 {
+      control.axis = number
+      control.sign = 1
+      if strings.HasSuffix(name, "-") {
+        control.sign = -1
+      }
+      return control, nil
+    }
+  }
+  return control, fmt.Errorf("unknown gamepad control %q", name)
+}
+
+func (c gamepadControl) pressed(id ebiten.GamepadID, deadZone float64) bool {
+  if ebiten.IsStandardGamepadLayoutAvailable(id) {
+    if c.standardButton != nil {
+      return ebiten.IsStandardGamepadButtonPressed(id, *c.standardButton)
+    }
+    if c.standardStick != nil {
+      return ebiten.StandardGamepadAxisValue(id, c.standardStick.axis)*c.standardStick.sign > deadZone
+    }
+  }
+  if c.button >= 0 {
+    return c.button < ebiten.GamepadButtonCount(id) && ebiten.IsGamepadButtonPressed(id, ebiten.GamepadButton(c.button))
+  }
+  if c.axis >= 0 && c.axis < ebiten.GamepadAxisCount(id) {
+    return ebiten.GamepadAxisValue(id, c.axis)*c.sign > deadZone
+  }
+  return false
+}
+
+// the parsed controls for each Chip8 key
+func gamepadControls(k keymap.Keymap) ([16][]gamepadControl, error) {
+  controls := [16][]gamepadControl{}
+  for key, names := range k {
+    for _, name := range names {
+      control, err := parseGamepadControl(name)
+      if err != nil {
+        return controls, fmt.Errorf("can't bind Chip8 key %X: %w", key, err)
+      }
+      controls[key] = append(controls[key], control)
+    }
+  }
+  return controls, nil
+}
+
+// Keeps track of connected gamepads; every pad presses keys, so anyone
+// can pick one up. Pads can be plugged in and out while running.
+type gamepads struct {
+  ids []ebiten.GamepadID
+}
+
+func (g *gamepads) update() {
+  for _, id := range inpututil.AppendJustConnectedGamepadIDs(nil) {
+    log.Printf("gamepad %d connected: %s", id, ebiten.GamepadName(id))
+  }
+  for _, id := range g.ids {
+    if inpututil.IsGamepadJustDisconnected(id) {
+      log.Printf("gamepad %d disconnected", id)
+    }
+  }
+  g.ids = ebiten.AppendGamepadIDs(g.ids[:0])
+}
+
+// whether any connected pad is pressing any of controls
+func (g *gamepads) pressed(controls []gamepadControl, deadZone float64) bool {
+  for _, id := range g.ids {
+    for _, control := range controls {
+      if control.pressed(id, deadZone) {
+        return true
+      }
+    }
+  }
+  return false
+}
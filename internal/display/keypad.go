@@ -0,0 +1,83 @@
+package display
+
+import (
+  "fmt"
+  "image/color"
+  "math"
+
+  "github.com/hajimehoshi/ebiten/v2"
+  "github.com/hajimehoshi/ebiten/v2/ebitenutil"
+  "jfeintzeig/chip8/internal/keymap"
+)
+
+// The on-screen keypad: the COSMAC VIP's 4x4 hex keypad drawn over the bottom
+// right of the window. It lights up the keys that are down, and clicking or
+// touching its buttons presses them. F5 shows and hides it.
+
+// how much of the window's shorter side the keypad takes up
+const KEYPAD_SIZE = 0.45
+// gap around the keypad and between its buttons, as a fraction of a button
+const KEYPAD_GAP = 0.08
+
+var keypadBackground = color.RGBA{0x20, 0x20, 0x20, 0xC0}
+var keypadButton = color.RGBA{0x50, 0x50, 0x50, 0xC0}
+
+// where the keypad goes in a window this size: its top left corner and the
+// size of each of its 16 cells
+func keypadRect(width int, height int) (x float64, y float64, cell float64) {
+  size := math.Min(float64(width), float64(height)) * KEYPAD_SIZE
+  cell = size / 4
+  margin := cell * KEYPAD_GAP
+  return float64(width) - size - margin, float64(height) - size - margin, cell
+}
+
+// the Chip8 key under a point in the window, or -1 if it's not on a button
+func keypadKeyAt(width int, height int, pointX int, pointY int) int {
+  x, y, cell := keypadRect(width, height)
+  column := int(math.Floor((float64(pointX) - x) / cell))
+  row := int(math.Floor((float64(pointY) - y) / cell))
+  if column < 0 || column > 3 || row < 0 || row > 3 {
+    return -1
+  }
+  return int(keymap.KEYPAD[row*4 + column])
+}
+
+// the keys being clicked or touched on the keypad
+func keypadPointers(width int, height int) [16]bool {
+  pressed := [16]bool{}
+  press := func(x int, y int) {
+    if key := keypadKeyAt(width, height, x, y); key >= 0 {
+      pressed[key] = true
+    }
+  }
+  if ebiten.IsMouseButtonPressed(ebiten.MouseButtonLeft) {
+    press(ebiten.CursorPosition())
+  }
+  for _, id := range ebiten.AppendTouchIDs(nil) {
+    press(ebiten.TouchPosition(id))
+  }
+  return pressed
+}
+
+// draws the keypad with the pressed keys in the foreground colour, and each
+// button labelled with its hex digit and the first host key bound to it
+func drawKeypad(window *ebiten.Image, pressed [16]bool, k keymap.Keymap, lit color.Color) {
+  width, height := window.Size()
+  x, y, cell := keypadRect(width, height)
+  gap := cell * KEYPAD_GAP
+  ebitenutil.DrawRect(window, x - gap, y - gap, cell*4 + gap*2, cell*4 + gap*2, keypadBackground)
+  for index, key := range keymap.KEYPAD {
+    buttonX := x + float64(index % 4)*cell + gap/2
+    buttonY := y + float64(index / 4)*cell + gap/2
+    colour := color.Color(keypadButton)
+    if pressed[key] {
+      colour = lit
+    }
+    ebitenutil.DrawRect(window, buttonX, buttonY, cell - gap, cell - gap, colour)
+    label := fmt.Sprintf("%X", key)
+    if len(k[key]) > 0 {
+      label += "\n" + k[key][0]
+    }
+    ebitenutil.DebugPrintAt(window, label, int(buttonX) + 4, int(buttonY) + 2)
+  }
+}
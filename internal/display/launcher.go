@@ -0,0 +1,348 @@
+package display
+
+import (
+  "fmt"
+  "image/color"
+  "log"
+  "math"
+  "sync"
+
+  "github.com/hajimehoshi/ebiten/v2"
+  "github.com/hajimehoshi/ebiten/v2/ebitenutil"
+  "github.com/hajimehoshi/ebiten/v2/inpututil"
+  "jfeintzeig/chip8/internal/cpu"
+  "jfeintzeig/chip8/internal/screen"
+)
+
+// how many frames a ROM runs for before its thumbnail is taken
+const THUMBNAIL_FRAMES = 120
+// height of each ROM in the list, which fits a 64x32 thumbnail and two lines of text
+const LAUNCHER_ROW_HEIGHT = 40
+const LAUNCHER_TITLE = "Chip8 library"
+
+var launcherHighlight = color.RGBA{0x30, 0x30, 0x50, 0xFF}
+
+// one ROM in the launcher
+type LibraryItem struct {
+  Name string
+  // e.g. the author and platform from the ROM database
+  Info string
+  // for screen.ParsePalette, the classic palette if empty
+  Palette string
+  // a fresh Chip8 with the ROM loaded, run headlessly for the thumbnails
+  Preview func() (*cpu.Chip8, error)
+}
+
+// An ebiten.Game that lists ROMs with a thumbnail of each, the highlighted
+// one running live, and runs the one picked in the same window until Esc
+// brings the list back.
+type Launcher struct {
+  items []LibraryItem
+  palettes []screen.Palette
+  // makes the Game for an item; it's run on its own goroutine until Esc
+  start func(index int) (*Game, error)
+  selected int
+  // the first row on screen
+  scroll int
+  // filled in by a goroutine as they're taken, so guarded by mu
+  mu sync.Mutex
+  thumbnails []*screen.Frame
+  images []*ebiten.Image
+  // the selected item, stepped once a tick
+  preview *cpu.Chip8
+  previewIndex int
+  previewImage *ebiten.Image
+  previewPixels []byte
+  // the running ROM, what stops its goroutine, and where that goroutine
+  // says it's finished, with the ROM's error if it hit one
+  game *Game
+  stop chan struct{}
+  done chan error
+  // the last thing that went wrong, shown at the bottom
+  message string
+  windowWidth int
+  windowHeight int
+}
+
+func NewLauncher(items []LibraryItem, start func(index int) (*Game, error)) *Launcher {
+  l := &Launcher{
+    items: items,
+    palettes: make([]screen.Palette, len(items)),
+    start: start,
+    thumbnails: make([]*screen.Frame, len(items)),
+    images: make([]*ebiten.Image, len(items)),
+    previewIndex: -1,
+  }
+  for index, item := range items {
+    l.palettes[index] = screen.CLASSIC
+    if item.Palette == "" {
+      continue
+    }
+    if palette, err := screen.ParsePalette(item.Palette); err == nil {
+      l.palettes[index] = palette
+    }
+  }
+  go l.takeThumbnails()
+  return l
+}
+
+func (l *Launcher) takeThumbnails() {
+  for index, item := range l.items {
+    c8, err := item.Preview()
+    if err != nil {
+      log.Printf("%s: %v", item.Name, err)
+      continue
+    }
+    for frame := 0; frame < THUMBNAIL_FRAMES; frame++ {
+      if err := c8.RunFrame(); err != nil {
+        break
+      }
+    }
+    width, height := c8.Resolution()
+    thumbnail := screen.NewFrame(c8.Display[:], width, height)
+    l.mu.Lock()
+    l.thumbnails[index] = &thumbnail
+    l.mu.Unlock()
+  }
+}
+
+func (l *Launcher) ConfigureWindow(scale int, fullscreen bool) {
+  if scale < 1 {
+    scale = 1
+  }
+  ebiten.SetWindowSize(cpu.DISPLAY_WIDTH*scale, cpu.DISPLAY_HEIGHT*scale)
+  ebiten.SetWindowResizingMode(ebiten.WindowResizingModeEnabled)
+  ebiten.SetFullscreen(fullscreen)
+  ebiten.SetWindowTitle(LAUNCHER_TITLE)
+}
+
+// starts the selected ROM
+func (l *Launcher) launch() {
+  if len(l.items) == 0 {
+    return
+  }
+  game, err := l.start(l.selected)
+  if err != nil {
+    l.message = err.Error()
+    log.Println(err)
+    return
+  }
+  stop, done := make(chan struct{}), make(chan error, 1)
+  l.game, l.stop, l.done, l.preview, l.previewIndex, l.message = game, stop, done, nil, -1, ""
+  game.updateTitle()
+  go func() {
+    done <- game.c8.ExecuteUntil(stop)
+  }()
+}
+
+// stops the running ROM, if there is one, and saves its recording
+func (l *Launcher) Close() {
+  if l.game == nil {
+    return
+  }
+  close(l.stop)
+  // the core draws and beeps through the game, so it has to be finished first
+  l.finish(<-l.done)
+}
+
+// back to the list once the ROM's goroutine has returned, showing err if it's set
+func (l *Launcher) finish(err error) {
+  if err != nil {
+    l.message = fmt.Sprintf("%s: %v", l.items[l.selected].Name, err)
+    log.Println(l.message)
+  }
+  l.game.Close()
+  l.game = nil
+  ebiten.SetWindowTitle(LAUNCHER_TITLE)
+}
+
+func (l *Launcher) rows() int {
+  return int(math.Max(1, float64((l.windowHeight - 2*LAUNCHER_ROW_HEIGHT) / LAUNCHER_ROW_HEIGHT)))
+}
+
+// the item under a point in the window, or -1
+func (l *Launcher) itemAt(x int, y int) int {
+  if x > l.windowWidth / 2 || y < LAUNCHER_ROW_HEIGHT {
+    return -1
+  }
+  index := l.scroll + (y - LAUNCHER_ROW_HEIGHT) / LAUNCHER_ROW_HEIGHT
+  if index >= len(l.items) || index >= l.scroll + l.rows() {
+    return -1
+  }
+  return index
+}
+
+func (l *Launcher) Update() error {
+  if l.game != nil {
+    select {
+    case err := <-l.done:
+      // the ROM stopped by itself, which only happens when it goes wrong
+      l.finish(err)
+      return nil
+    default:
+    }
+    // Esc cancels the F4 screen rather than leaving the ROM
+    if inpututil.IsKeyJustPressed(ebiten.KeyEscape) && l.game.binding == nil {
+      l.Close()
+      return nil
+    }
+    return l.game.Update()
+  }
+  if inpututil.IsKeyJustPressed(ebiten.KeyF11) {
+    ebiten.SetFullscreen(!ebiten.IsFullscreen())
+  }
+  move := 0
+  switch {
+  case inpututil.IsKeyJustPressed(ebiten.KeyArrowDown):
+    move = 1
+  case inpututil.IsKeyJustPressed(ebiten.KeyArrowUp):
+    move = -1
+  case inpututil.IsKeyJustPressed(ebiten.KeyPageDown):
+    move = l.rows()
+  case inpututil.IsKeyJustPressed(ebiten.KeyPageUp):
+    move = -l.rows()
+  }
+  if len(l.items) > 0 {
+    l.selected = int(math.Max(0, math.Min(float64(len(l.items) - 1), float64(l.selected + move))))
+  }
+  if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+    // a click picks a ROM, and another plays it
+    if index := l.itemAt(ebiten.CursorPosition()); index >= 0 {
+      if index == l.selected {
+        l.launch()
+        return nil
+      }
+      l.selected = index
+    }
+  }
+  if l.selected < l.scroll {
+    l.scroll = l.selected
+  }
+  if l.selected >= l.scroll + l.rows() {
+    l.scroll = l.selected - l.rows() + 1
+  }
+  if inpututil.IsKeyJustPressed(ebiten.KeyEnter) || inpututil.IsKeyJustPressed(ebiten.KeySpace) {
+    l.launch()
+    return nil
+  }
+  l.updatePreview()
+  return nil
+}
+
+// keeps the selected item running, starting it over when the selection changes
+func (l *Launcher) updatePreview() {
+  if len(l.items) == 0 {
+    return
+  }
+  if l.previewIndex != l.selected {
+    l.previewIndex = l.selected
+    preview, err := l.items[l.selected].Preview()
+    if err != nil {
+      l.message = err.Error()
+    }
+    l.preview = preview
+  }
+  if l.preview == nil {
+    return
+  }
+  if err := l.preview.RunFrame(); err != nil {
+    l.message = fmt.Sprintf("%s: %v", l.items[l.selected].Name, err)
+    l.preview = nil
+  }
+}
+
+// the selected item's live screen, or its thumbnail if it isn't running
+func (l *Launcher) previewFrame() *ebiten.Image {
+  if l.preview == nil {
+    return l.thumbnail(l.selected)
+  }
+  width, height := l.preview.Resolution()
+  if l.previewImage == nil || l.previewImage.Bounds().Dx() != width || l.previewImage.Bounds().Dy() != height {
+    l.previewImage = ebiten.NewImage(width, height)
+    l.previewPixels = make([]byte, 4*width*height)
+  }
+  palette := l.palettes[l.selected]
+  for index, pixel := range l.preview.Display[:width*height] {
+    colour := palette.Color(pixel)
+    copy(l.previewPixels[index*4:], []byte{colour.R, colour.G, colour.B, colour.A})
+  }
+  l.previewImage.WritePixels(l.previewPixels)
+  return l.previewImage
+}
+
+// nil until it's been taken
+func (l *Launcher) thumbnail(index int) *ebiten.Image {
+  if l.images[index] != nil {
+    return l.images[index]
+  }
+  l.mu.Lock()
+  frame := l.thumbnails[index]
+  l.mu.Unlock()
+  if frame == nil {
+    return nil
+  }
+  l.images[index] = ebiten.NewImageFromImage(frame.ImageWithPalette(1, l.palettes[index]))
+  return l.images[index]
+}
+
+func (l *Launcher) Draw(window *ebiten.Image) {
+  if l.game != nil {
+    l.game.Draw(window)
+    return
+  }
+  ebitenutil.DebugPrintAt(window, "Up/Down or click to choose, Enter or click again to play, Esc comes back here", 8, 8)
+  if len(l.items) == 0 {
+    ebitenutil.DebugPrintAt(window, "No ROMs found", 8, LAUNCHER_ROW_HEIGHT)
+    return
+  }
+  var live *ebiten.Image
+  if l.preview != nil {
+    live = l.previewFrame()
+  }
+  for row := 0; row < l.rows() && l.scroll + row < len(l.items); row++ {
+    index := l.scroll + row
+    y := LAUNCHER_ROW_HEIGHT * (row + 1)
+    if index == l.selected {
+      ebitenutil.DrawRect(window, 0, float64(y), float64(l.windowWidth / 2), LAUNCHER_ROW_HEIGHT, launcherHighlight)
+    }
+    thumbnail := l.thumbnail(index)
+    if index == l.selected && live != nil {
+      thumbnail = live
+    }
+    if thumbnail != nil {
+      op := &ebiten.DrawImageOptions{}
+      op.GeoM.Translate(8, float64(y + 4))
+      window.DrawImage(thumbnail, op)
+    }
+    ebitenutil.DebugPrintAt(window, l.items[index].Name, 80, y + 2)
+    ebitenutil.DebugPrintAt(window, l.items[index].Info, 80, y + 18)
+  }
+
+  // the selected one, as big as fits in the right half
+  big := live
+  if big == nil {
+    big = l.thumbnail(l.selected)
+  }
+  if big != nil {
+    width, height := big.Size()
+    area := float64(l.windowWidth / 2 - 16)
+    scale := math.Min(area / float64(width), float64(l.windowHeight - 2*LAUNCHER_ROW_HEIGHT) / float64(height))
+    if scale > 0 {
+      op := &ebiten.DrawImageOptions{}
+      op.GeoM.Scale(scale, scale)
+      op.GeoM.Translate(float64(l.windowWidth / 2 + 8), LAUNCHER_ROW_HEIGHT)
+      window.DrawImage(big, op)
+    }
+  }
+  if l.message != "" {
+    ebitenutil.DebugPrintAt(window, l.message, 8, l.windowHeight - 20)
+  }
+}
+
+func (l *Launcher) Layout(outsideWidth, outsideHeight int) (screenWidth, screenHeight int) {
+  l.windowWidth, l.windowHeight = outsideWidth, outsideHeight
+  if l.game != nil {
+    return l.game.Layout(outsideWidth, outsideHeight)
+  }
+  return outsideWidth, outsideHeight
+}
@@ -0,0 +1,97 @@
+package keymap
+
+import (
+  "fmt"
+  "strings"
+)
+
+// Gamepad bindings use the same Keymap type, with controls in place of
+// host keys. On pads ebiten knows the layout of, the controls are named
+// after an Xbox style pad:
+//     A B X Y L1 R1 L2 R2 Select Start Home LeftStick RightStick
+//     DPadUp DPadDown DPadLeft DPadRight
+//     LeftStickUp LeftStickDown LeftStickLeft LeftStickRight (and RightStick...)
+// and on any pad raw buttons and axes can be used by number:
+//     Button0 Button1 ... Axis0+ Axis0- Axis1+ ...
+
+// how far a stick has to move, from 0 to 1, before it presses a key
+const DEFAULT_DEAD_ZONE = 0.25
+
+// the d-pad and left stick both steer
+func directions(up uint8, down uint8, left uint8, right uint8) Keymap {
+  k := Keymap{}
+  k[up] = []string{"DPadUp", "LeftStickUp"}
+  k[down] = []string{"DPadDown", "LeftStickDown"}
+  k[left] = []string{"DPadLeft", "LeftStickLeft"}
+  k[right] = []string{"DPadRight", "LeftStickRight"}
+  return k
+}
+
+func profile(k Keymap, buttons map[uint8]string) Keymap {
+  for key, button := range buttons {
+    k[key] = append(k[key], button)
+  }
+  return k
+}
+
+var GAMEPAD_PROFILES = map[string]Keymap{
+  // 2, 8, 4 and 6 steer, like on a phone keypad; what most of the classic games use
+  "numeric": profile(directions(0x2, 0x8, 0x4, 0x6), map[uint8]string{
+    0x5: "A", 0x0: "B", 0x1: "X", 0x3: "Y", 0xE: "Select", 0xF: "Start",
+  }),
+  // 5, 8, 7 and 9 steer, the W A S D keys on the qwerty layout
+  "wasd": profile(directions(0x5, 0x8, 0x7, 0x9), map[uint8]string{
+    0x6: "A", 0x4: "B", 0xA: "X", 0xC: "Y", 0xE: "Select", 0xF: "Start",
+  }),
+}
+
+var GAMEPAD_PROFILE_NAMES = []string{"numeric", "wasd"}
+
+const DEFAULT_GAMEPAD_PROFILE = "numeric"
+
+// a copy of a named gamepad profile
+func GamepadProfile(name string) (Keymap, error) {
+  if name == "" {
+    name = DEFAULT_GAMEPAD_PROFILE
+  }
+  p, ok := GAMEPAD_PROFILES[strings.ToLower(name)]
+  if !ok {
+    return Keymap{}, fmt.Errorf("unknown gamepad profile %q, want one of %v", name, GAMEPAD_PROFILE_NAMES)
+  }
+  k := Keymap{}
+  for key := range p {
+    k[key] = append([]string{}, p[key]...)
+  }
+  return k, nil
+}
+
+// the gamepad profile with the Gamepad overrides applied, like Keymap for the keyboard
+func (b Bindings) GamepadMap() (Keymap, error) {
+  k, err := GamepadProfile(b.GamepadProfile)
+  if err != nil {
+    return k, err
+  }
+  for digit, names := range b.Gamepad {
+    key, err := parseKey(digit)
+    if err != nil {
+      return k, err
+    }
+    k[key] = append([]string{}, names...)
+  }
+  return k, nil
+}
+
+// 0 or less would press keys with the stick at rest, and 1 or more never would
+func CheckDeadZone(deadZone float64) error {
+  if !(deadZone > 0 && deadZone < 1) {
+    return fmt.Errorf("dead zone %v is outside 0 to 1", deadZone)
+  }
+  return nil
+}
+
+func (b Bindings) GetDeadZone() float64 {
+  if b.DeadZone <= 0 {
+    return DEFAULT_DEAD_ZONE
+  }
+  return b.DeadZone
+}
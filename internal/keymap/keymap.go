@@ -0,0 +1,183 @@
+package keymap
+
+import (
+  "fmt"
+  "strconv"
+  "strings"
+)
+
+// The host keys for each of the 16 Chip8 keys, as ebiten key names like
+// "X", "Digit1" or "Numpad7" (see ebiten.Key.String). A Chip8 key can have
+// several host keys, and any of them holds it down.
+type Keymap [16][]string
+
+// the Chip8 keypad as it's laid out on the COSMAC VIP, row by row
+var KEYPAD = [16]uint8{
+  0x1, 0x2, 0x3, 0xC,
+  0x4, 0x5, 0x6, 0xD,
+  0x7, 0x8, 0x9, 0xE,
+  0xA, 0x0, 0xB, 0xF,
+}
+
+// host keys in the same 4x4 arrangement as KEYPAD
+func grid(rows ...string) Keymap {
+  k := Keymap{}
+  for index, name := range strings.Fields(strings.Join(rows, " ")) {
+    k[KEYPAD[index]] = []string{name}
+  }
+  return k
+}
+
+var LAYOUTS = map[string]Keymap{
+  // the left of the keyboard has the same shape as the keypad
+  "qwerty": grid(
+    "Digit1 Digit2 Digit3 Digit4",
+    "Q W E R",
+    "A S D F",
+    "Z X C V",
+  ),
+  // the same keys on a French keyboard
+  "azerty": grid(
+    "Digit1 Digit2 Digit3 Digit4",
+    "A Z E R",
+    "Q S D F",
+    "W X C V",
+  ),
+  // digits on the numpad, A-F on the keys around it
+  "numpad": Keymap{
+    {"Numpad0"}, {"Numpad1"}, {"Numpad2"}, {"Numpad3"},
+    {"Numpad4"}, {"Numpad5"}, {"Numpad6"}, {"Numpad7"},
+    {"Numpad8"}, {"Numpad9"}, {"NumpadDivide"}, {"NumpadMultiply"},
+    {"NumpadSubtract"}, {"NumpadAdd"}, {"NumpadEnter"}, {"NumpadDecimal"},
+  },
+  // each key is the host key with the same hex digit on it
+  "cosmac": Keymap{
+    {"Digit0"}, {"Digit1"}, {"Digit2"}, {"Digit3"},
+    {"Digit4"}, {"Digit5"}, {"Digit6"}, {"Digit7"},
+    {"Digit8"}, {"Digit9"}, {"A"}, {"B"},
+    {"C"}, {"D"}, {"E"}, {"F"},
+  },
+}
+
+var LAYOUT_NAMES = []string{"qwerty", "azerty", "numpad", "cosmac"}
+
+const DEFAULT_LAYOUT = "qwerty"
+
+// a copy of a named layout, so changing it doesn't change LAYOUTS
+func Layout(name string) (Keymap, error) {
+  if name == "" {
+    name = DEFAULT_LAYOUT
+  }
+  layout, ok := LAYOUTS[strings.ToLower(name)]
+  if !ok {
+    return Keymap{}, fmt.Errorf("unknown key layout %q, want one of %v", name, LAYOUT_NAMES)
+  }
+  k := Keymap{}
+  for key := range layout {
+    k[key] = append([]string{}, layout[key]...)
+  }
+  return k, nil
+}
+
+// key bindings as they're saved in the config, e.g.
+//     {"layout": "azerty", "keys": {"5": ["Z", "ArrowUp"]},
+//      "gamepadProfile": "wasd", "gamepad": {"5": ["A"]}, "deadZone": 0.3}
+// starts from the layout and replaces the host keys of any Chip8 key listed
+// in keys, and the same for the gamepad, see gamepad.go
+type Bindings struct {
+  Layout string `json:"layout,omitempty"`
+  // by Chip8 key as a hex digit
+  Keys map[string][]string `json:"keys,omitempty"`
+  GamepadProfile string `json:"gamepadProfile,omitempty"`
+  Gamepad map[string][]string `json:"gamepad,omitempty"`
+  DeadZone float64 `json:"deadZone,omitempty"`
+}
+
+func parseKey(digit string) (uint8, error) {
+  key, err := strconv.ParseUint(digit, 16, 8)
+  if err != nil || key > 0xF {
+    return 0, fmt.Errorf("bad Chip8 key %q in key bindings, want a hex digit 0-F", digit)
+  }
+  return uint8(key), nil
+}
+
+func (b Bindings) Keymap() (Keymap, error) {
+  k, err := Layout(b.Layout)
+  if err != nil {
+    return k, err
+  }
+  for digit, names := range b.Keys {
+    key, err := parseKey(digit)
+    if err != nil {
+      return k, err
+    }
+    k[key] = append([]string{}, names...)
+  }
+  return k, nil
+}
+
+// rom's bindings on top of global's; either can be nil
+func Merge(global *Bindings, rom *Bindings) Bindings {
+  merged := Bindings{Keys: map[string][]string{}, Gamepad: map[string][]string{}}
+  for _, b := range []*Bindings{global, rom} {
+    if b == nil {
+      continue
+    }
+    if b.Layout != "" {
+      merged.Layout = b.Layout
+      // a different layout starts over, rather than mixing with the last one's overrides
+      merged.Keys = map[string][]string{}
+    }
+    for digit, names := range b.Keys {
+      merged.Keys[strings.ToUpper(digit)] = names
+    }
+    if b.GamepadProfile != "" {
+      merged.GamepadProfile = b.GamepadProfile
+      merged.Gamepad = map[string][]string{}
+    }
+    for digit, names := range b.Gamepad {
+      merged.Gamepad[strings.ToUpper(digit)] = names
+    }
+    if b.DeadZone > 0 {
+      merged.DeadZone = b.DeadZone
+    }
+  }
+  return merged
+}
+
+// the bindings that turn the layout into k: the layout plus every key that differs from it
+func Diff(layout string, k Keymap) Bindings {
+  if layout == "" {
+    // an explicit layout, so Merge doesn't mix in overrides from elsewhere
+    layout = DEFAULT_LAYOUT
+  }
+  b := Bindings{Layout: layout, Keys: map[string][]string{}}
+  base, err := Layout(layout)
+  if err != nil {
+    base = Keymap{}
+  }
+  for key := range k {
+    if strings.Join(k[key], " ") != strings.Join(base[key], " ") {
+      b.Keys[fmt.Sprintf("%X", key)] = k[key]
+    }
+  }
+  return b
+}
+
+// "Q/ArrowUp" for a key with two host keys, "-" for none
+func (k Keymap) Describe(key uint8) string {
+  if len(k[key]) == 0 {
+    return "-"
+  }
+  return strings.Join(k[key], "/")
+}
+
+// true if no Chip8 key has a host key
+func (k Keymap) Empty() bool {
+  for key := range k {
+    if len(k[key]) > 0 {
+      return false
+    }
+  }
+  return true
+}
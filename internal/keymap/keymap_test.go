@@ -0,0 +1,86 @@
+package keymap
+
+import (
+  "math"
+  "reflect"
+  "testing"
+)
+
+func TestLayouts(t *testing.T) {
+  for _, name := range LAYOUT_NAMES {
+    k, err := Layout(name)
+    if err != nil {
+      t.Fatal(err)
+    }
+    seen := map[string]bool{}
+    for key := range k {
+      if len(k[key]) != 1 || seen[k[key][0]] {
+        t.Errorf("%s: key %X bound to %v", name, key, k[key])
+      }
+      seen[k[key][0]] = true
+    }
+  }
+  // the layout the window always had
+  qwerty, _ := Layout("qwerty")
+  if qwerty[0x0][0] != "X" || qwerty[0xC][0] != "Digit4" || qwerty[0xF][0] != "V" {
+    t.Errorf("qwerty has 0, C and F on %v, %v and %v", qwerty[0x0], qwerty[0xC], qwerty[0xF])
+  }
+}
+
+func TestMerge(t *testing.T) {
+  global := &Bindings{Layout: "azerty", Keys: map[string][]string{"5": {"ArrowUp"}}}
+  rom := &Bindings{Keys: map[string][]string{"a": {"Space", "Enter"}}}
+  k, err := Merge(global, rom).Keymap()
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !reflect.DeepEqual(k[0x5], []string{"ArrowUp"}) || !reflect.DeepEqual(k[0xA], []string{"Space", "Enter"}) || k[0x4][0] != "A" {
+    t.Errorf("got 5: %v, A: %v, 4: %v", k[0x5], k[0xA], k[0x4])
+  }
+
+  // a layout in the ROM's bindings replaces the global overrides too
+  k, _ = Merge(global, &Bindings{Layout: "cosmac"}).Keymap()
+  if k[0x5][0] != "Digit5" {
+    t.Errorf("cosmac 5 is %v", k[0x5])
+  }
+}
+
+func TestDiff(t *testing.T) {
+  k, _ := Layout("numpad")
+  k[0x2] = []string{"ArrowDown", "S"}
+  b := Diff("numpad", k)
+  if len(b.Keys) != 1 {
+    t.Errorf("got %v, want only key 2", b.Keys)
+  }
+  back, err := Merge(&Bindings{Keys: map[string][]string{"3": {"M"}}}, &b).Keymap()
+  if err != nil || !reflect.DeepEqual(back, k) {
+    t.Errorf("round trip gave %v, %v", back, err)
+  }
+}
+
+func TestGamepadBindings(t *testing.T) {
+  global := &Bindings{GamepadProfile: "wasd", Gamepad: map[string][]string{"6": {"R1"}}, DeadZone: 0.4}
+  rom := &Bindings{Gamepad: map[string][]string{"f": {"Button9"}}}
+  merged := Merge(global, rom)
+  k, err := merged.GamepadMap()
+  if err != nil {
+    t.Fatal(err)
+  }
+  if k[0x5][0] != "DPadUp" || k[0x6][0] != "R1" || k[0xF][0] != "Button9" {
+    t.Errorf("got 5: %v, 6: %v, F: %v", k[0x5], k[0x6], k[0xF])
+  }
+  if merged.GetDeadZone() != 0.4 || (Bindings{}).GetDeadZone() != DEFAULT_DEAD_ZONE {
+    t.Errorf("dead zone %v", merged.GetDeadZone())
+  }
+  if _, err := (Bindings{GamepadProfile: "joystick"}).GamepadMap(); err == nil {
+    t.Error("unknown profile should fail")
+  }
+  for _, deadZone := range []float64{-0.5, 0, 1, 2, math.NaN()} {
+    if CheckDeadZone(deadZone) == nil {
+      t.Errorf("dead zone %v was accepted", deadZone)
+    }
+  }
+  if err := CheckDeadZone(DEFAULT_DEAD_ZONE); err != nil {
+    t.Error(err)
+  }
+}
@@ -0,0 +1,143 @@
+package library
+
+import (
+  "bytes"
+  "fmt"
+  "log"
+  "os"
+  "path/filepath"
+  "sort"
+  "strings"
+
+  "jfeintzeig/chip8/internal/config"
+  "jfeintzeig/chip8/internal/cpu"
+  "jfeintzeig/chip8/internal/octo"
+  "jfeintzeig/chip8/internal/romdb"
+)
+
+// Octo cartridges, on top of cpu.ROM_EXTENSIONS and .zip archives
+const CARTRIDGE_EXTENSION = ".gif"
+
+// a ROM ready to run, with whatever the ROM database or its cartridge says about it
+type ROM struct {
+  Path string
+  // the bytes to load, assembled if it was a cartridge
+  Program []byte
+  // config.ROMKey of Program
+  Key string
+  // Known is false if neither the database nor a cartridge had anything to say
+  Entry romdb.Entry
+  Known bool
+  Cartridge bool
+}
+
+// reads the ROM at path, see cpu.ReadROM, and looks it up in db, which can be nil
+func Load(path string, db *romdb.Database) (ROM, error) {
+  program, err := cpu.ReadROM(path)
+  if err != nil {
+    return ROM{}, err
+  }
+  rom := ROM{Path: path, Program: program}
+  if strings.EqualFold(filepath.Ext(path), CARTRIDGE_EXTENSION) {
+    // Octo cartridges carry their source and settings instead of a ROM
+    cartridge, err := octo.Decode(bytes.NewReader(program))
+    if err != nil {
+      return ROM{}, fmt.Errorf("%s: %w", path, err)
+    }
+    if rom.Program, err = cartridge.ROM(); err != nil {
+      return ROM{}, fmt.Errorf("%s: %w", path, err)
+    }
+    rom.Entry, rom.Known, rom.Cartridge = cartridge.Options.Entry(fileName(path)), true, true
+  }
+  rom.Key = config.ROMKey(rom.Program)
+  if db != nil && !rom.Known {
+    rom.Entry, rom.Known = db.Lookup(rom.Key)
+  }
+  return rom, nil
+}
+
+// every ROM in dir, sorted by name; files that can't be loaded are logged and skipped
+func Scan(dir string, db *romdb.Database) ([]ROM, error) {
+  files, err := os.ReadDir(dir)
+  if err != nil {
+    return nil, err
+  }
+  roms := []ROM{}
+  for _, f := range files {
+    if f.IsDir() || !isROM(f.Name()) {
+      continue
+    }
+    rom, err := Load(filepath.Join(dir, f.Name()), db)
+    if err != nil {
+      log.Printf("skipping %s: %v", f.Name(), err)
+      continue
+    }
+    roms = append(roms, rom)
+  }
+  sort.SliceStable(roms, func(i int, j int) bool {
+    return strings.ToLower(roms[i].Name()) < strings.ToLower(roms[j].Name())
+  })
+  return roms, nil
+}
+
+func isROM(name string) bool {
+  extension := strings.ToLower(filepath.Ext(name))
+  if extension == ".zip" || extension == CARTRIDGE_EXTENSION {
+    return true
+  }
+  for _, romExtension := range cpu.ROM_EXTENSIONS {
+    if extension == romExtension {
+      return true
+    }
+  }
+  return false
+}
+
+func fileName(path string) string {
+  return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+}
+
+// the title from the database, or the file name
+func (r ROM) Name() string {
+  if r.Entry.Title != "" {
+    return r.Entry.Title
+  }
+  return fileName(r.Path)
+}
+
+// e.g. "by Someone - superchip - 600 Hz", empty if nothing's known
+func (r ROM) Info() string {
+  if !r.Known {
+    return ""
+  }
+  parts := []string{}
+  if len(r.Entry.Authors) > 0 {
+    parts = append(parts, "by " + strings.Join(r.Entry.Authors, ", "))
+  }
+  if r.Entry.Platform != "" {
+    parts = append(parts, r.Entry.Platform)
+  }
+  parts = append(parts, fmt.Sprintf("%d Hz", r.Entry.ClockSpeed))
+  return strings.Join(parts, " - ")
+}
+
+// the quirks the ROM wants, or fallback if it isn't known
+func (r ROM) Quirks(fallback cpu.Quirks) cpu.Quirks {
+  if r.Known {
+    return r.Entry.Quirks
+  }
+  return fallback
+}
+
+// a Chip8 with the ROM loaded, at the speed and on the platform it wants
+func (r ROM) NewChip8(debug bool, quirks cpu.Quirks) (*cpu.Chip8, error) {
+  c8 := cpu.NewChip8(debug, quirks)
+  c8.SetPlatform(r.Entry.Machine)
+  if r.Entry.ClockSpeed > 0 {
+    c8.SetClockSpeed(r.Entry.ClockSpeed)
+  }
+  if err := c8.LoadBytes(r.Program); err != nil {
+    return nil, fmt.Errorf("%s: %w", r.Path, err)
+  }
+  return c8, nil
+}
@@ -0,0 +1,63 @@
+package library
+
+import (
+  "os"
+  "path/filepath"
+  "testing"
+
+  "jfeintzeig/chip8/internal/cpu"
+  "jfeintzeig/chip8/internal/romdb"
+)
+
+func TestScan(t *testing.T) {
+  dir := t.TempDir()
+  bcd, err := os.ReadFile(filepath.Join("..", "cpu", "testdata", "roms", "bcd.ch8"))
+  if err != nil {
+    t.Fatal(err)
+  }
+  files := map[string][]byte{
+    "bcd.ch8": bcd,
+    "loop.c8": {0x12, 0x00},
+    "notes.txt": []byte("not a ROM"),
+    "broken.zip": []byte("not a zip"),
+  }
+  for name, contents := range files {
+    if err := os.WriteFile(filepath.Join(dir, name), contents, 0644); err != nil {
+      t.Fatal(err)
+    }
+  }
+  db, err := romdb.Load(filepath.Join("..", "romdb", "testdata"))
+  if err != nil {
+    t.Fatal(err)
+  }
+  roms, err := Scan(dir, db)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(roms) != 2 {
+    t.Fatalf("got %d ROMs, want bcd.ch8 and loop.c8", len(roms))
+  }
+
+  // sorted by name, and the database's title comes first
+  known, unknown := roms[0], roms[1]
+  if known.Name() != "BCD test" || !known.Known || known.Info() != "by jfeintzeig - originalChip8 - 600 Hz" {
+    t.Errorf("got %q (%q), want bcd.ch8 from the database", known.Name(), known.Info())
+  }
+  if unknown.Name() != "loop" || unknown.Known || unknown.Info() != "" {
+    t.Errorf("got %q (%q), want loop.c8 by its file name", unknown.Name(), unknown.Info())
+  }
+
+  if quirks := known.Quirks(cpu.MODERN_QUIRKS); quirks != cpu.LEGACY_QUIRKS {
+    t.Errorf("got quirks %+v, want the database's", quirks)
+  }
+  if quirks := unknown.Quirks(cpu.MODERN_QUIRKS); quirks != cpu.MODERN_QUIRKS {
+    t.Errorf("got quirks %+v, want the fallback", quirks)
+  }
+  c8, err := known.NewChip8(false, known.Quirks(cpu.MODERN_QUIRKS))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if c8.ClockSpeed() != 600 || len(c8.Program()) != len(bcd) {
+    t.Errorf("got %d Hz and %d bytes", c8.ClockSpeed(), len(c8.Program()))
+  }
+}
@@ -0,0 +1,986 @@
+package octo
+
+import (
+  "fmt"
+  "math"
+  "strconv"
+  "strings"
+
+  "jfeintzeig/chip8/internal/cpu"
+)
+
+// An assembler for Octo (https://github.com/JohnEarnest/Octo), the language
+// Octo cartridges carry their programs in. It covers the statements,
+// control flow and directives; :stringmode and :assert aren't supported,
+// and nor are the SUPER-CHIP and XO-CHIP instructions the cpu can't run.
+// :calc expressions are evaluated right to left without precedence, as in Octo.
+
+// macros calling themselves would otherwise expand forever: no macro can be
+// expanded inside more than MAX_MACRO_DEPTH others, and all of them together
+// can't add more than MAX_MACRO_TOKENS tokens
+const MAX_MACRO_DEPTH = 64
+const MAX_MACRO_TOKENS = 1 << 20
+
+type token struct {
+  text string
+  line int
+}
+
+// where a value that wasn't known yet has to be written once it is
+type fixup struct {
+  address int
+  label string
+  line int
+  kind fixupKind
+}
+
+type fixupKind int
+
+const (
+  // the low 12 bits of the 2 byte instruction at address
+  ADDRESS_12 fixupKind = iota
+  // 2 bytes, big endian, for :pointer
+  ADDRESS_16
+  // the immediates of the v0 := and v1 := that :unpack writes
+  UNPACK
+)
+
+// an if, else or loop that hasn't been closed yet
+type block struct {
+  kind string
+  // the start of a loop, or the jump to patch at else or end
+  address int
+  // the jumps out of a loop that again has to patch
+  whiles []int
+}
+
+type macro struct {
+  args []string
+  body []token
+}
+
+// tokens being read: the program, with a macro's body on top of it while
+// it's being expanded
+type tokenSource struct {
+  tokens []token
+  position int
+}
+
+type assembler struct {
+  sources []tokenSource
+  // tokens added by macros so far
+  expanded int
+  rom []byte
+  here int
+  labels map[string]int
+  constants map[string]float64
+  aliases map[string]uint8
+  macros map[string]macro
+  fixups []fixup
+  blocks []block
+  // whether 0x200 holds the jump to main
+  hasMain bool
+  line int
+}
+
+// compiles Octo source to a ROM that starts at cpu.PROGRAM_START
+func Assemble(source string) ([]byte, error) {
+  a := &assembler{
+    sources: []tokenSource{{tokens: tokenize(source)}},
+    here: int(cpu.PROGRAM_START),
+    labels: map[string]int{},
+    constants: map[string]float64{},
+    aliases: map[string]uint8{},
+    macros: map[string]macro{},
+  }
+  // room for jump main, dropped if main comes first
+  a.emit(0x00, 0x00)
+  a.hasMain = true
+  for a.peek() != "" {
+    if err := a.statement(); err != nil {
+      return nil, fmt.Errorf("line %d: %w", a.line, err)
+    }
+  }
+  if len(a.blocks) > 0 {
+    return nil, fmt.Errorf("%s without end", a.blocks[len(a.blocks)-1].kind)
+  }
+  if a.hasMain {
+    main, ok := a.labels["main"]
+    if !ok {
+      return nil, fmt.Errorf("the program has no main")
+    }
+    a.rom[0], a.rom[1] = 0x10 | byte(main >> 8), byte(main)
+  }
+  for _, f := range a.fixups {
+    address, ok := a.labels[f.label]
+    if !ok {
+      return nil, fmt.Errorf("line %d: undefined name %q", f.line, f.label)
+    }
+    offset := f.address - int(cpu.PROGRAM_START)
+    switch f.kind {
+    case ADDRESS_12:
+      if address > 0xFFF {
+        return nil, fmt.Errorf("line %d: %s is at %X, past 12 bits", f.line, f.label, address)
+      }
+      a.rom[offset] |= byte(address >> 8)
+      a.rom[offset+1] = byte(address)
+    case ADDRESS_16:
+      a.rom[offset], a.rom[offset+1] = byte(address >> 8), byte(address)
+    case UNPACK:
+      a.rom[offset+1] |= byte(address >> 8) & 0xF
+      a.rom[offset+3] = byte(address)
+    }
+  }
+  return a.rom, nil
+}
+
+// whitespace separated, with # comments to the end of the line
+func tokenize(source string) []token {
+  tokens := []token{}
+  for index, line := range strings.Split(source, "\n") {
+    for _, text := range strings.Fields(line) {
+      if strings.HasPrefix(text, "#") {
+        break
+      }
+      tokens = append(tokens, token{text, index + 1})
+    }
+  }
+  return tokens
+}
+
+func (a *assembler) nextToken() (token, error) {
+  for len(a.sources) > 0 {
+    top := &a.sources[len(a.sources)-1]
+    if top.position < len(top.tokens) {
+      t := top.tokens[top.position]
+      top.position++
+      a.line = t.line
+      return t, nil
+    }
+    // the end of a macro's body
+    a.sources = a.sources[:len(a.sources)-1]
+  }
+  return token{}, fmt.Errorf("unexpected end of program")
+}
+
+func (a *assembler) next() (string, error) {
+  t, err := a.nextToken()
+  return t.text, err
+}
+
+// puts back the token next just read
+func (a *assembler) back() {
+  a.sources[len(a.sources)-1].position--
+}
+
+// the text of the token n after the next one, or "" past the end
+func (a *assembler) lookahead(n int) string {
+  for index := len(a.sources) - 1; index >= 0; index-- {
+    s := a.sources[index]
+    remaining := len(s.tokens) - s.position
+    if n < remaining {
+      return s.tokens[s.position+n].text
+    }
+    n -= remaining
+  }
+  return ""
+}
+
+func (a *assembler) peek() string {
+  return a.lookahead(0)
+}
+
+func (a *assembler) expect(want string) error {
+  got, err := a.next()
+  if err != nil {
+    return err
+  }
+  if got != want {
+    return fmt.Errorf("expected %q, got %q", want, got)
+  }
+  return nil
+}
+
+func (a *assembler) emit(bytes ...byte) {
+  for _, b := range bytes {
+    offset := a.here - int(cpu.PROGRAM_START)
+    for len(a.rom) <= offset {
+      a.rom = append(a.rom, 0)
+    }
+    a.rom[offset] = b
+    a.here++
+  }
+}
+
+func (a *assembler) instruction(opcode uint16) {
+  a.emit(byte(opcode >> 8), byte(opcode))
+}
+
+// writes the 2 byte instruction at address again, for patching jumps
+func (a *assembler) patch(address int, opcode uint16) {
+  offset := address - int(cpu.PROGRAM_START)
+  a.rom[offset], a.rom[offset+1] = byte(opcode >> 8), byte(opcode)
+}
+
+func parseNumber(text string) (float64, bool) {
+  negative := strings.HasPrefix(text, "-")
+  digits := strings.TrimPrefix(text, "-")
+  var value int64
+  var err error
+  switch {
+  case strings.HasPrefix(digits, "0x"), strings.HasPrefix(digits, "0X"):
+    value, err = strconv.ParseInt(digits[2:], 16, 64)
+  case strings.HasPrefix(digits, "0b"), strings.HasPrefix(digits, "0B"):
+    value, err = strconv.ParseInt(digits[2:], 2, 64)
+  default:
+    value, err = strconv.ParseInt(digits, 10, 64)
+  }
+  if err != nil {
+    return 0, false
+  }
+  if negative {
+    value = -value
+  }
+  return float64(value), true
+}
+
+func (a *assembler) register(text string) (uint8, bool) {
+  if register, ok := a.aliases[text]; ok {
+    return register, true
+  }
+  if len(text) == 2 && (text[0] == 'v' || text[0] == 'V') {
+    if register, err := strconv.ParseUint(text[1:], 16, 8); err == nil {
+      return uint8(register), true
+    }
+  }
+  return 0, false
+}
+
+func (a *assembler) nextRegister() (uint8, error) {
+  text, err := a.next()
+  if err != nil {
+    return 0, err
+  }
+  register, ok := a.register(text)
+  if !ok {
+    return 0, fmt.Errorf("expected a register, got %q", text)
+  }
+  return register, nil
+}
+
+// a number, constant, known label or { calc expression }; name is set
+// instead if it's a name that isn't known yet
+func (a *assembler) value() (value float64, name string, err error) {
+  text, err := a.next()
+  if err != nil {
+    return 0, "", err
+  }
+  if text == "{" {
+    value, err = a.calc()
+    return value, "", err
+  }
+  if number, ok := parseNumber(text); ok {
+    return number, "", nil
+  }
+  if constant, ok := a.constants[text]; ok {
+    return constant, "", nil
+  }
+  if label, ok := a.labels[text]; ok {
+    return float64(label), "", nil
+  }
+  if _, ok := a.register(text); ok || keywords[text] {
+    return 0, "", fmt.Errorf("expected a value, got %q", text)
+  }
+  return 0, text, nil
+}
+
+// a value that has to be known now, e.g. for a byte
+func (a *assembler) known() (int, error) {
+  value, name, err := a.value()
+  if err != nil {
+    return 0, err
+  }
+  if name != "" {
+    return 0, fmt.Errorf("undefined name %q", name)
+  }
+  return int(math.Floor(value)), nil
+}
+
+func (a *assembler) byteValue() (byte, error) {
+  value, err := a.known()
+  if err != nil {
+    return 0, err
+  }
+  if value < -128 || value > 255 {
+    return 0, fmt.Errorf("%d doesn't fit in a byte", value)
+  }
+  return byte(value), nil
+}
+
+func (a *assembler) nibble() (uint16, error) {
+  value, err := a.known()
+  if err != nil {
+    return 0, err
+  }
+  if value < 0 || value > 15 {
+    return 0, fmt.Errorf("%d doesn't fit in a nibble", value)
+  }
+  return uint16(value), nil
+}
+
+// emits opcode with a 12 bit address, patched later if it's a label that isn't defined yet
+func (a *assembler) addressInstruction(opcode uint16) error {
+  value, name, err := a.value()
+  if err != nil {
+    return err
+  }
+  if name != "" {
+    a.fixups = append(a.fixups, fixup{a.here, name, a.line, ADDRESS_12})
+    a.instruction(opcode)
+    return nil
+  }
+  address := int(value)
+  if address < 0 || address > 0xFFF {
+    return fmt.Errorf("address %X doesn't fit in 12 bits", address)
+  }
+  a.instruction(opcode | uint16(address))
+  return nil
+}
+
+func (a *assembler) address16() error {
+  value, name, err := a.value()
+  if err != nil {
+    return err
+  }
+  if name != "" {
+    a.fixups = append(a.fixups, fixup{a.here, name, a.line, ADDRESS_16})
+    a.emit(0, 0)
+    return nil
+  }
+  a.emit(byte(int(value) >> 8), byte(int(value)))
+  return nil
+}
+
+// words that can't be call targets
+var keywords = map[string]bool{
+  ":=": true, "+=": true, "-=": true, "=-": true, "|=": true, "&=": true, "^=": true, ">>=": true, "<<=": true,
+  "==": true, "!=": true, "<": true, ">": true, "<=": true, ">=": true, "key": true, "-key": true,
+  "if": true, "then": true, "begin": true, "else": true, "end": true, "loop": true, "again": true, "while": true,
+  "i": true, "random": true, "delay": true, "buzzer": true, "pitch": true, "hex": true, "bighex": true, "long": true,
+  "{": true, "}": true,
+}
+
+func (a *assembler) statement() error {
+  text, err := a.next()
+  if err != nil {
+    return err
+  }
+  if register, ok := a.register(text); ok {
+    return a.registerStatement(register)
+  }
+  if number, ok := parseNumber(text); ok {
+    if number < -128 || number > 255 {
+      return fmt.Errorf("%s doesn't fit in a byte", text)
+    }
+    a.emit(byte(int(number)))
+    return nil
+  }
+  if m, ok := a.macros[text]; ok {
+    return a.expand(m)
+  }
+  if strings.HasPrefix(text, ":") && text != ":" {
+    return a.directive(text)
+  }
+  switch text {
+  case ":":
+    name, err := a.next()
+    if err != nil {
+      return err
+    }
+    if _, ok := a.labels[name]; ok {
+      return fmt.Errorf("%s is already defined", name)
+    }
+    if name == "main" && a.hasMain && a.here == int(cpu.PROGRAM_START) + 2 && len(a.rom) == 2 {
+      // main is first, so it doesn't need jumping to
+      a.hasMain = false
+      a.rom = nil
+      a.here = int(cpu.PROGRAM_START)
+    }
+    a.labels[name] = a.here
+  case "clear":
+    a.instruction(0x00E0)
+  case "return", ";":
+    a.instruction(0x00EE)
+  case "scroll-down", "scroll-up", "scroll-right", "scroll-left", "exit", "lores", "hires", "plane", "saveflags", "loadflags", "native":
+    return unsupported(text)
+  case "audio":
+    a.instruction(0xF002)
+  case "jump":
+    return a.addressInstruction(0x1000)
+  case "jump0":
+    return a.addressInstruction(0xB000)
+  case "bcd":
+    x, err := a.nextRegister()
+    if err != nil {
+      return err
+    }
+    a.instruction(0xF033 | uint16(x) << 8)
+  case "save", "load":
+    x, err := a.nextRegister()
+    if err != nil {
+      return err
+    }
+    if a.peek() == "-" {
+      // XO-CHIP's ranges
+      return unsupported(text + " vx - vy")
+    }
+    low := uint16(0x55)
+    if text == "load" {
+      low = 0x65
+    }
+    a.instruction(0xF000 | uint16(x) << 8 | low)
+  case "sprite":
+    x, err := a.nextRegister()
+    if err != nil {
+      return err
+    }
+    y, err := a.nextRegister()
+    if err != nil {
+      return err
+    }
+    n, err := a.nibble()
+    if err != nil {
+      return err
+    }
+    a.instruction(0xD000 | uint16(x) << 8 | uint16(y) << 4 | n)
+  case "delay", "buzzer", "pitch":
+    if err := a.expect(":="); err != nil {
+      return err
+    }
+    x, err := a.nextRegister()
+    if err != nil {
+      return err
+    }
+    low := map[string]uint16{"delay": 0x15, "buzzer": 0x18, "pitch": 0x3A}[text]
+    a.instruction(0xF000 | uint16(x) << 8 | low)
+  case "i":
+    return a.indexStatement()
+  case "if":
+    return a.ifStatement()
+  case "else":
+    if len(a.blocks) == 0 || a.blocks[len(a.blocks)-1].kind != "if" {
+      return fmt.Errorf("else without if ... begin")
+    }
+    top := &a.blocks[len(a.blocks)-1]
+    jump := a.here
+    a.instruction(0x1000)
+    a.patch(top.address, 0x1000 | uint16(a.here))
+    top.kind, top.address = "else", jump
+  case "end":
+    if len(a.blocks) == 0 || a.blocks[len(a.blocks)-1].kind == "loop" {
+      return fmt.Errorf("end without if ... begin")
+    }
+    top := a.blocks[len(a.blocks)-1]
+    a.blocks = a.blocks[:len(a.blocks)-1]
+    a.patch(top.address, 0x1000 | uint16(a.here))
+  case "loop":
+    a.blocks = append(a.blocks, block{kind: "loop", address: a.here})
+  case "while":
+    loop := -1
+    for index := len(a.blocks) - 1; index >= 0; index-- {
+      if a.blocks[index].kind == "loop" {
+        loop = index
+        break
+      }
+    }
+    if loop < 0 {
+      return fmt.Errorf("while outside a loop")
+    }
+    if err := a.condition(true); err != nil {
+      return err
+    }
+    a.blocks[loop].whiles = append(a.blocks[loop].whiles, a.here)
+    a.instruction(0x1000)
+  case "again":
+    if len(a.blocks) == 0 || a.blocks[len(a.blocks)-1].kind != "loop" {
+      return fmt.Errorf("again without loop")
+    }
+    top := a.blocks[len(a.blocks)-1]
+    a.blocks = a.blocks[:len(a.blocks)-1]
+    a.instruction(0x1000 | uint16(top.address))
+    for _, while := range top.whiles {
+      a.patch(while, 0x1000 | uint16(a.here))
+    }
+  default:
+    if keywords[text] {
+      return fmt.Errorf("unexpected %q", text)
+    }
+    // a subroutine, possibly defined further on
+    a.back()
+    return a.addressInstruction(0x2000)
+  }
+  return nil
+}
+
+// for the SUPER-CHIP and XO-CHIP instructions the cpu doesn't run
+func unsupported(statement string) error {
+  return fmt.Errorf("%s is not supported by this emulator", statement)
+}
+
+func (a *assembler) registerStatement(x uint8) error {
+  op, err := a.next()
+  if err != nil {
+    return err
+  }
+  operand, err := a.next()
+  if err != nil {
+    return err
+  }
+  X := uint16(x) << 8
+  if y, ok := a.register(operand); ok {
+    Y := uint16(y) << 4
+    low, ok := map[string]uint16{":=": 0, "|=": 1, "&=": 2, "^=": 3, "+=": 4, "-=": 5, ">>=": 6, "=-": 7, "<<=": 0xE}[op]
+    if !ok {
+      return fmt.Errorf("can't %s a register", op)
+    }
+    a.instruction(0x8000 | X | Y | low)
+    return nil
+  }
+  if op == ":=" {
+    switch operand {
+    case "key":
+      a.instruction(0xF00A | X)
+      return nil
+    case "delay":
+      a.instruction(0xF007 | X)
+      return nil
+    case "random":
+      mask, err := a.byteValue()
+      if err != nil {
+        return err
+      }
+      a.instruction(0xC000 | X | uint16(mask))
+      return nil
+    }
+  }
+  a.back()
+  n, err := a.byteValue()
+  if err != nil {
+    return err
+  }
+  switch op {
+  case ":=":
+    a.instruction(0x6000 | X | uint16(n))
+  case "+=":
+    a.instruction(0x7000 | X | uint16(n))
+  case "-=":
+    a.instruction(0x7000 | X | uint16(byte(-int(n))))
+  default:
+    return fmt.Errorf("can't %s a number", op)
+  }
+  return nil
+}
+
+func (a *assembler) indexStatement() error {
+  op, err := a.next()
+  if err != nil {
+    return err
+  }
+  if op == "+=" {
+    x, err := a.nextRegister()
+    if err != nil {
+      return err
+    }
+    a.instruction(0xF01E | uint16(x) << 8)
+    return nil
+  }
+  if op != ":=" {
+    return fmt.Errorf("can't %s i", op)
+  }
+  switch a.peek() {
+  case "hex":
+    a.next()
+    x, err := a.nextRegister()
+    if err != nil {
+      return err
+    }
+    a.instruction(0xF029 | uint16(x) << 8)
+    return nil
+  case "bighex", "long":
+    return unsupported("i := " + a.peek())
+  }
+  return a.addressInstruction(0xA000)
+}
+
+func (a *assembler) ifStatement() error {
+  // then or begin comes after the register, the comparison and, unless
+  // it's key or -key, the operand
+  form := 3
+  if op := a.lookahead(1); op == "key" || op == "-key" {
+    form--
+  }
+  switch a.lookahead(form) {
+  case "":
+    return fmt.Errorf("if without then or begin")
+  case "then":
+    if err := a.condition(false); err != nil {
+      return err
+    }
+    return a.expect("then")
+  case "begin":
+    if err := a.condition(true); err != nil {
+      return err
+    }
+    if err := a.expect("begin"); err != nil {
+      return err
+    }
+    a.blocks = append(a.blocks, block{kind: "if", address: a.here})
+    a.instruction(0x1000)
+    return nil
+  }
+  return fmt.Errorf("expected then or begin, got %q", a.lookahead(form))
+}
+
+// Emits the test for a condition, which skips the next instruction when
+// the condition is false, or with inverted when it's true. The comparisons
+// CHIP-8 doesn't have are worked out in vf.
+func (a *assembler) condition(inverted bool) error {
+  x, err := a.nextRegister()
+  if err != nil {
+    return err
+  }
+  X := uint16(x) << 8
+  op, err := a.next()
+  if err != nil {
+    return err
+  }
+  skip := func(ifFalse uint16, ifTrue uint16) {
+    if inverted {
+      a.instruction(ifTrue)
+    } else {
+      a.instruction(ifFalse)
+    }
+  }
+  switch op {
+  case "key":
+    skip(0xE0A1 | X, 0xE09E | X)
+    return nil
+  case "-key":
+    skip(0xE09E | X, 0xE0A1 | X)
+    return nil
+  }
+  operand, err := a.next()
+  if err != nil {
+    return err
+  }
+  y, isRegister := a.register(operand)
+  Y := uint16(y) << 4
+  var n byte
+  if !isRegister {
+    a.back()
+    if n, err = a.byteValue(); err != nil {
+      return err
+    }
+  }
+  switch op {
+  case "==", "!=":
+    equal, notEqual := 0x5000 | X | Y, 0x9000 | X | Y
+    if !isRegister {
+      equal, notEqual = 0x3000 | X | uint16(n), 0x4000 | X | uint16(n)
+    }
+    if op == "==" {
+      skip(notEqual, equal)
+    } else {
+      skip(equal, notEqual)
+    }
+    return nil
+  case "<", ">", "<=", ">=":
+    // vf := the right hand side
+    if isRegister {
+      a.instruction(0x8F00 | Y)
+    } else {
+      a.instruction(0x6F00 | uint16(n))
+    }
+    if op == "<" || op == ">=" {
+      // vf = vx - rhs, and 1 if vx >= rhs
+      a.instruction(0x8F07 | X >> 4)
+    } else {
+      // vf = rhs - vx, and 1 if rhs >= vx
+      a.instruction(0x8F05 | X >> 4)
+    }
+    // vf is 0 when < or > holds, 1 when >= or <= does
+    want := uint16(0)
+    if op == "<=" || op == ">=" {
+      want = 1
+    }
+    skip(0x4F00 | want, 0x3F00 | want)
+    return nil
+  }
+  return fmt.Errorf("unknown comparison %q", op)
+}
+
+func (a *assembler) directive(text string) error {
+  switch text {
+  case ":const":
+    name, err := a.next()
+    if err != nil {
+      return err
+    }
+    value, err := a.known()
+    if err != nil {
+      return err
+    }
+    a.constants[name] = float64(value)
+  case ":alias":
+    name, err := a.next()
+    if err != nil {
+      return err
+    }
+    register, err := a.nextRegister()
+    if err != nil {
+      return err
+    }
+    a.aliases[name] = register
+  case ":unpack":
+    high, err := a.nibble()
+    if err != nil {
+      return err
+    }
+    value, name, err := a.value()
+    if err != nil {
+      return err
+    }
+    if name != "" {
+      a.fixups = append(a.fixups, fixup{a.here, name, a.line, UNPACK})
+      value = 0
+    }
+    address := int(value)
+    a.instruction(0x6000 | high << 4 | uint16(address >> 8) & 0xF)
+    a.instruction(0x6100 | uint16(address) & 0xFF)
+  case ":next":
+    name, err := a.next()
+    if err != nil {
+      return err
+    }
+    a.labels[name] = a.here + 1
+  case ":org":
+    address, err := a.known()
+    if err != nil {
+      return err
+    }
+    if address < int(cpu.PROGRAM_START) || address >= cpu.XO_CHIP_MEMORY_SIZE {
+      return fmt.Errorf(":org %X is outside the program", address)
+    }
+    a.here = address
+  case ":byte":
+    value, err := a.byteValue()
+    if err != nil {
+      return err
+    }
+    a.emit(value)
+  case ":pointer":
+    return a.address16()
+  case ":call":
+    return a.addressInstruction(0x2000)
+  case ":calc":
+    name, err := a.next()
+    if err != nil {
+      return err
+    }
+    if err := a.expect("{"); err != nil {
+      return err
+    }
+    value, err := a.calc()
+    if err != nil {
+      return err
+    }
+    a.constants[name] = value
+  case ":macro":
+    return a.defineMacro()
+  case ":breakpoint", ":proto":
+    _, err := a.next()
+    return err
+  case ":monitor":
+    if _, err := a.next(); err != nil {
+      return err
+    }
+    _, err := a.next()
+    return err
+  default:
+    return fmt.Errorf("%s isn't supported", text)
+  }
+  return nil
+}
+
+func (a *assembler) defineMacro() error {
+  name, err := a.next()
+  if err != nil {
+    return err
+  }
+  m := macro{}
+  for {
+    text, err := a.next()
+    if err != nil {
+      return err
+    }
+    if text == "{" {
+      break
+    }
+    m.args = append(m.args, text)
+  }
+  depth := 1
+  for {
+    if a.peek() == "" {
+      return fmt.Errorf("macro %s has no closing }", name)
+    }
+    t, err := a.nextToken()
+    if err != nil {
+      return err
+    }
+    if t.text == "{" {
+      depth++
+    }
+    if t.text == "}" {
+      depth--
+      if depth == 0 {
+        break
+      }
+    }
+    m.body = append(m.body, t)
+  }
+  a.macros[name] = m
+  return nil
+}
+
+// reads the macro's body, with its arguments filled in, before the rest of the program
+func (a *assembler) expand(m macro) error {
+  if len(a.sources) > MAX_MACRO_DEPTH {
+    return fmt.Errorf("macros nested more than %d deep, does one call itself?", MAX_MACRO_DEPTH)
+  }
+  if a.expanded += len(m.body); a.expanded > MAX_MACRO_TOKENS {
+    return fmt.Errorf("macros expanded to more than %d tokens, does one call itself?", MAX_MACRO_TOKENS)
+  }
+  values := map[string]string{}
+  for _, arg := range m.args {
+    value, err := a.next()
+    if err != nil {
+      return err
+    }
+    values[arg] = value
+  }
+  body := make([]token, len(m.body))
+  for index, t := range m.body {
+    if value, ok := values[t.text]; ok {
+      t.text = value
+    }
+    body[index] = t
+  }
+  a.sources = append(a.sources, tokenSource{tokens: body})
+  return nil
+}
+
+var unaryOperators = map[string]func(float64) float64{
+  "-": func(x float64) float64 { return -x },
+  "~": func(x float64) float64 { return float64(^int(x)) },
+  "!": func(x float64) float64 { return boolean(x == 0) },
+  "sin": math.Sin,
+  "cos": math.Cos,
+  "tan": math.Tan,
+  "exp": math.Exp,
+  "log": math.Log,
+  "abs": math.Abs,
+  "sqrt": math.Sqrt,
+  "sign": func(x float64) float64 { return boolean(x > 0) - boolean(x < 0) },
+  "ceil": math.Ceil,
+  "floor": math.Floor,
+}
+
+var binaryOperators = map[string]func(float64, float64) float64{
+  "-": func(x, y float64) float64 { return x - y },
+  "+": func(x, y float64) float64 { return x + y },
+  "*": func(x, y float64) float64 { return x * y },
+  "/": func(x, y float64) float64 { return x / y },
+  "%": func(x, y float64) float64 { return math.Mod(x, y) },
+  "&": func(x, y float64) float64 { return float64(int(x) & int(y)) },
+  "|": func(x, y float64) float64 { return float64(int(x) | int(y)) },
+  "^": func(x, y float64) float64 { return float64(int(x) ^ int(y)) },
+  "<<": func(x, y float64) float64 { return float64(int(x) << uint(y)) },
+  ">>": func(x, y float64) float64 { return float64(int(x) >> uint(y)) },
+  "pow": math.Pow,
+  "min": math.Min,
+  "max": math.Max,
+  "<": func(x, y float64) float64 { return boolean(x < y) },
+  ">": func(x, y float64) float64 { return boolean(x > y) },
+  "<=": func(x, y float64) float64 { return boolean(x <= y) },
+  ">=": func(x, y float64) float64 { return boolean(x >= y) },
+  "==": func(x, y float64) float64 { return boolean(x == y) },
+  "!=": func(x, y float64) float64 { return boolean(x != y) },
+}
+
+func boolean(b bool) float64 {
+  if b {
+    return 1
+  }
+  return 0
+}
+
+// the expression after a {, up to and including its }
+func (a *assembler) calc() (float64, error) {
+  value, err := a.expression()
+  if err != nil {
+    return 0, err
+  }
+  return value, a.expect("}")
+}
+
+func (a *assembler) expression() (float64, error) {
+  left, err := a.term()
+  if err != nil {
+    return 0, err
+  }
+  operator, ok := binaryOperators[a.peek()]
+  if !ok {
+    return left, nil
+  }
+  a.next()
+  right, err := a.expression()
+  if err != nil {
+    return 0, err
+  }
+  return operator(left, right), nil
+}
+
+func (a *assembler) term() (float64, error) {
+  text, err := a.next()
+  if err != nil {
+    return 0, err
+  }
+  if operator, ok := unaryOperators[text]; ok {
+    value, err := a.term()
+    return operator(value), err
+  }
+  switch text {
+  case "(":
+    value, err := a.expression()
+    if err != nil {
+      return 0, err
+    }
+    return value, a.expect(")")
+  case "HERE":
+    return float64(a.here), nil
+  case "PI":
+    return math.Pi, nil
+  case "E":
+    return math.E, nil
+  }
+  if number, ok := parseNumber(text); ok {
+    return number, nil
+  }
+  if constant, ok := a.constants[text]; ok {
+    return constant, nil
+  }
+  if label, ok := a.labels[text]; ok {
+    return float64(label), nil
+  }
+  return 0, fmt.Errorf("undefined name %q in expression", text)
+}
@@ -0,0 +1,113 @@
+package octo
+
+import (
+  "encoding/json"
+  "fmt"
+  "image/gif"
+  "io"
+  "strings"
+
+  "jfeintzeig/chip8/internal/cpu"
+  "jfeintzeig/chip8/internal/romdb"
+)
+
+// Octo cartridges are GIFs with a program hidden in them: every pixel's
+// colour index carries 2 bits in its low bits, 4 pixels to a byte, high
+// bits first, running across the frames in order. The bytes are a 4 byte
+// big endian length and then that much JSON, {"program": <Octo source>,
+// "options": {...}}. The rest of each index draws the label.
+
+// the settings Octo saves with a program, as it names them
+type Options struct {
+  // instructions per frame
+  Tickrate int `json:"tickrate"`
+  BackgroundColor string `json:"backgroundColor"`
+  FillColor string `json:"fillColor"`
+  FillColor2 string `json:"fillColor2"`
+  BlendColor string `json:"blendColor"`
+  // 8XY6 and 8XYE shift VX in place
+  ShiftQuirks bool `json:"shiftQuirks"`
+  // FX55 and FX65 leave I alone
+  LoadStoreQuirks bool `json:"loadStoreQuirks"`
+  // BNNN jumps to XNN + VX
+  JumpQuirks bool `json:"jumpQuirks"`
+  // 8XY1, 8XY2 and 8XY3 reset VF
+  LogicQuirks bool `json:"logicQuirks"`
+  // the biggest ROM the program's platform takes, 3584 for CHIP-8
+  MaxSize int `json:"maxSize"`
+}
+
+type Cartridge struct {
+  Source string `json:"program"`
+  Options Options `json:"options"`
+}
+
+// reads the payload out of a cartridge
+func Decode(r io.Reader) (Cartridge, error) {
+  image, err := gif.DecodeAll(r)
+  if err != nil {
+    return Cartridge{}, err
+  }
+  pairs := []byte{}
+  for _, frame := range image.Image {
+    bounds := frame.Bounds()
+    for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+      for x := bounds.Min.X; x < bounds.Max.X; x++ {
+        pairs = append(pairs, frame.ColorIndexAt(x, y) & 0x3)
+      }
+    }
+  }
+  data := make([]byte, len(pairs) / 4)
+  for index := range data {
+    for _, pair := range pairs[index*4:index*4+4] {
+      data[index] = data[index] << 2 | pair
+    }
+  }
+  if len(data) < 4 {
+    return Cartridge{}, fmt.Errorf("not an Octo cartridge, it's too small")
+  }
+  size := int(data[0]) << 24 | int(data[1]) << 16 | int(data[2]) << 8 | int(data[3])
+  if size > len(data) - 4 {
+    return Cartridge{}, fmt.Errorf("not an Octo cartridge, it says it holds %d bytes but only has room for %d", size, len(data) - 4)
+  }
+  cartridge := Cartridge{}
+  if err := json.Unmarshal(data[4:4+size], &cartridge); err != nil {
+    return Cartridge{}, fmt.Errorf("not an Octo cartridge: %w", err)
+  }
+  return cartridge, nil
+}
+
+// the cartridge's program, assembled
+func (c Cartridge) ROM() ([]byte, error) {
+  return Assemble(c.Source)
+}
+
+// The options in the form the ROM database uses, so they configure a Chip8
+// the same way; title is shown in the window.
+func (o Options) Entry(title string) romdb.Entry {
+  entry := romdb.Entry{
+    Title: title,
+    Platform: "octo",
+    Quirks: cpu.Quirks{
+      ResetVF: o.LogicQuirks,
+      ShiftVY: !o.ShiftQuirks,
+      JumpVX: o.JumpQuirks,
+      IncrementI: !o.LoadStoreQuirks,
+    },
+    ClockSpeed: cpu.CLOCK_SPEED,
+  }
+  if o.Tickrate > 0 {
+    entry.ClockSpeed = romdb.ClockSpeed(o.Tickrate)
+  }
+  if o.MaxSize > cpu.CHIP_8.MaxROMSize() {
+    entry.Machine = cpu.XO_CHIP
+  }
+  if o.BackgroundColor != "" && o.FillColor != "" {
+    colours := []string{o.BackgroundColor, o.FillColor}
+    if o.FillColor2 != "" && o.BlendColor != "" {
+      colours = append(colours, o.FillColor2, o.BlendColor)
+    }
+    entry.Palette = strings.Join(colours, ",")
+  }
+  return entry
+}
@@ -0,0 +1,193 @@
+package octo
+
+import (
+  "bytes"
+  "encoding/json"
+  "image"
+  "image/color"
+  "image/gif"
+  "strings"
+  "testing"
+
+  "jfeintzeig/chip8/internal/cpu"
+)
+
+func TestAssemble(t *testing.T) {
+  tests := []struct {
+    source string
+    want []byte
+  }{
+    // main comes first, so there's no jump to it
+    {": main clear v1 := 0x20 i := dot sprite v0 v1 1 ; : dot 0b10000000",
+      []byte{0x00, 0xE0, 0x61, 0x20, 0xA2, 0x0A, 0xD0, 0x11, 0x00, 0xEE, 0x80}},
+    {": f ; : main f jump main",
+      []byte{0x12, 0x04, 0x00, 0xEE, 0x22, 0x02, 0x12, 0x04}},
+    {": main v0 -= 1 v0 =- v1 :pointer main",
+      []byte{0x70, 0xFF, 0x80, 0x17, 0x02, 0x00}},
+    {"# a comment\n: main if v2 key then vf := delay  # another\n save v3 buzzer := v0",
+      []byte{0xE2, 0xA1, 0xFF, 0x07, 0xF3, 0x55, 0xF0, 0x18}},
+  }
+  for _, test := range tests {
+    got, err := Assemble(test.source)
+    if err != nil {
+      t.Errorf("%q: %v", test.source, err)
+      continue
+    }
+    if !bytes.Equal(got, test.want) {
+      t.Errorf("%q: got % X, want % X", test.source, got, test.want)
+    }
+  }
+
+  for _, source := range []string{": f ;", ": main loop", ": main v0 := 256", ": main jump nowhere", ": main :stringmode"} {
+    if _, err := Assemble(source); err == nil {
+      t.Errorf("%q should be an error", source)
+    }
+  }
+
+  // instructions the cpu can't run
+  for _, source := range []string{": main hires", ": main scroll-down 4", ": main plane 3", ": main i := long main",
+    ": main save v1 - v3", ": main saveflags v2", ": main i := bighex v0", ": main native main"} {
+    if _, err := Assemble(source); err == nil || !strings.Contains(err.Error(), "not supported by this emulator") {
+      t.Errorf("%q: got %v", source, err)
+    }
+  }
+}
+
+func TestMacroLimits(t *testing.T) {
+  for _, source := range []string{
+    // calls itself last, and in the middle
+    ":macro forever { forever } : main forever",
+    ":macro forever { clear forever clear } : main forever",
+    // each one doubles the last, so it blows up without being recursive
+    ":macro a { clear clear } :macro b { a a a a a a a a } :macro c { b b b b b b b b } " +
+      ":macro d { c c c c c c c c } :macro e { d d d d d d d d } :macro f { e e e e e e e e } " +
+      ":macro g { f f f f f f f f } :macro h { g g g g g g g g } : main h",
+  } {
+    if _, err := Assemble(source); err == nil || !strings.Contains(err.Error(), "does one call itself") {
+      t.Errorf("%.40q: got %v", source, err)
+    }
+  }
+
+  // nested ones that stop are fine
+  got, err := Assemble(":macro inner { clear } :macro outer { inner inner } : main outer outer")
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(got) != 8 {
+    t.Errorf("got % X, want 4 clears", got)
+  }
+}
+
+func TestAssembledProgramRuns(t *testing.T) {
+  source := `
+    :alias counter v0
+    :macro add-to REG N { REG += N }
+    # right to left, so 2 * ( 3 + 2 )
+    :calc TEN { 2 * 3 + 2 }
+    : main
+      counter := 0
+      loop
+        while counter < TEN
+        add-to counter 1
+        if counter >= 5 begin
+          v1 += 1
+        else
+          v2 += 1
+        end
+      again
+      v3 := 200
+      if v3 > 100 then v4 := 1
+      if v3 <= 100 then v5 := 1
+      v6 := v0
+      :unpack 0xA data
+    : halt
+      jump halt
+    : data
+      1 2 3
+  `
+  program, err := Assemble(source)
+  if err != nil {
+    t.Fatal(err)
+  }
+  c8 := cpu.NewChip8(false, cpu.MODERN_QUIRKS)
+  if err := c8.LoadBytes(program); err != nil {
+    t.Fatal(err)
+  }
+  for frame := 0; frame < 30; frame++ {
+    if err := c8.RunFrame(); err != nil {
+      t.Fatal(err)
+    }
+  }
+  v := c8.State().V
+  data := int(cpu.PROGRAM_START) + len(program) - 3
+  want := [16]uint8{0xA0 | uint8(data >> 8), uint8(data), 4, 200, 1, 0, 10}
+  want[0xF] = v[0xF]
+  if v != want {
+    t.Errorf("got registers % X, want % X", v, want)
+  }
+}
+
+// a cartridge the way Octo makes them, over two frames
+func cartridgeGIF(t *testing.T, c Cartridge) []byte {
+  payload, err := json.Marshal(c)
+  if err != nil {
+    t.Fatal(err)
+  }
+  data := append([]byte{0, 0, byte(len(payload) >> 8), byte(len(payload))}, payload...)
+  palette := color.Palette{}
+  for index := 0; index < 16; index++ {
+    palette = append(palette, color.Gray{uint8(index * 16)})
+  }
+  width, height := 32, (len(data)*4 / 32 + 2) / 2 + 1
+  pixels := 0
+  animation := &gif.GIF{}
+  for frame := 0; frame < 2; frame++ {
+    img := image.NewPaletted(image.Rect(0, 0, width, height), palette)
+    for index := range img.Pix {
+      bits := uint8(0)
+      if pixels / 4 < len(data) {
+        bits = data[pixels / 4] >> (6 - 2*(pixels % 4)) & 0x3
+      }
+      // the label, in the high bits
+      img.Pix[index] = uint8(index % 4) << 2 | bits
+      pixels++
+    }
+    animation.Image = append(animation.Image, img)
+    animation.Delay = append(animation.Delay, 0)
+  }
+  var buf bytes.Buffer
+  if err := gif.EncodeAll(&buf, animation); err != nil {
+    t.Fatal(err)
+  }
+  return buf.Bytes()
+}
+
+func TestDecode(t *testing.T) {
+  want := Cartridge{
+    Source: ": main\n  clear\n  loop again\n",
+    Options: Options{Tickrate: 20, BackgroundColor: "#000000", FillColor: "#FF8800",
+      ShiftQuirks: true, LoadStoreQuirks: true, MaxSize: 3584},
+  }
+  got, err := Decode(bytes.NewReader(cartridgeGIF(t, want)))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if got != want {
+    t.Errorf("got %+v, want %+v", got, want)
+  }
+  entry := got.Options.Entry("test")
+  if entry.ClockSpeed != 1200 || entry.Palette != "#000000,#FF8800" || entry.Machine != cpu.CHIP_8 {
+    t.Errorf("got %+v", entry)
+  }
+  if want := (cpu.Quirks{}); entry.Quirks != want {
+    t.Errorf("got quirks %+v, want %+v", entry.Quirks, want)
+  }
+  rom, err := got.ROM()
+  if err != nil || !bytes.Equal(rom, []byte{0x00, 0xE0, 0x12, 0x02}) {
+    t.Errorf("got % X, %v", rom, err)
+  }
+
+  if _, err := Decode(bytes.NewReader(cartridgeGIF(t, Cartridge{})[:20])); err == nil {
+    t.Error("a broken GIF should be an error")
+  }
+}
@@ -0,0 +1,228 @@
+package romdb
+
+import (
+  "embed"
+  "encoding/json"
+  "fmt"
+  "io/fs"
+  "os"
+  "path"
+  "sort"
+  "strings"
+
+  "jfeintzeig/chip8/internal/cpu"
+)
+
+// Known ROMs, looked up by sha1, in the format of the community CHIP-8
+// database (https://github.com/chip-8/chip-8-database): programs.json lists
+// the programs, sha1-hashes.json points each ROM's hash at one of them, and
+// platforms.json has each platform's quirks and speed. The copy in data/ is
+// a small subset, each ROM in it checked against a copy in the repo, e.g.
+// internal/cpu/testdata/roms/ibm_logo.ch8; scripts/update-romdb.sh replaces
+// it with the full community files and their licence, or point Load at a checkout.
+
+//go:embed data/*.json
+var bundled embed.FS
+
+// the quirks as the community database names them; any it leaves out of a
+// ROM's quirkyPlatforms come from the platform
+type PlatformQuirks map[string]bool
+
+type Platform struct {
+  ID string `json:"id"`
+  Name string `json:"name"`
+  // instructions per frame
+  DefaultTickrate int `json:"defaultTickrate"`
+  Quirks PlatformQuirks `json:"quirks"`
+}
+
+type Colors struct {
+  // background first, then foreground, then the XO-CHIP planes
+  Pixels []string `json:"pixels"`
+  Buzzer string `json:"buzzer"`
+  Silence string `json:"silence"`
+}
+
+// one version of a program
+type ROM struct {
+  File string `json:"file"`
+  // the first one is what it was written for
+  Platforms []string `json:"platforms"`
+  QuirkyPlatforms map[string]PlatformQuirks `json:"quirkyPlatforms"`
+  // instructions per frame
+  Tickrate int `json:"tickrate"`
+  Colors *Colors `json:"colors"`
+  // what the game uses each key for, e.g. {"up": 5, "a": 6}
+  Keys map[string]int `json:"keys"`
+}
+
+type Program struct {
+  Title string `json:"title"`
+  Description string `json:"description"`
+  Release string `json:"release"`
+  Authors []string `json:"authors"`
+  ROMs map[string]ROM `json:"roms"`
+}
+
+type Database struct {
+  Programs []Program
+  // sha1 to index into Programs
+  Hashes map[string]int
+  Platforms map[string]Platform
+}
+
+// what the database says about one ROM, ready to configure a Chip8 with
+type Entry struct {
+  Title string
+  Authors []string
+  // the platform's id, e.g. "superchip"
+  Platform string
+  // the one of ours with enough memory for it
+  Machine cpu.Platform
+  Quirks cpu.Quirks
+  // instructions per second, see Chip8.SetClockSpeed
+  ClockSpeed uint16
+  // for screen.ParsePalette, empty if the ROM doesn't have colours
+  Palette string
+  Keys map[string]int
+}
+
+// the platforms whose FX0A finishes on key down rather than key up
+var keyPressPlatforms = map[string]bool{
+  "chip48": true,
+  "superchip1": true,
+  "superchip": true,
+}
+
+// the database in data/
+func Bundled() (*Database, error) {
+  return load(bundled, "data")
+}
+
+// a database from a directory holding programs.json, sha1-hashes.json and platforms.json
+func Load(dir string) (*Database, error) {
+  return load(os.DirFS(dir), ".")
+}
+
+func load(fsys fs.FS, dir string) (*Database, error) {
+  db := &Database{}
+  read := func(name string, value interface{}) error {
+    data, err := fs.ReadFile(fsys, path.Join(dir, name))
+    if err != nil {
+      return err
+    }
+    if err := json.Unmarshal(data, value); err != nil {
+      return fmt.Errorf("%s: %w", name, err)
+    }
+    return nil
+  }
+  if err := read("programs.json", &db.Programs); err != nil {
+    return nil, err
+  }
+  if err := read("sha1-hashes.json", &db.Hashes); err != nil {
+    return nil, err
+  }
+  platforms := []Platform{}
+  if err := read("platforms.json", &platforms); err != nil {
+    return nil, err
+  }
+  db.Platforms = map[string]Platform{}
+  for _, platform := range platforms {
+    db.Platforms[platform.ID] = platform
+  }
+  for hash, index := range db.Hashes {
+    if index < 0 || index >= len(db.Programs) {
+      return nil, fmt.Errorf("sha1-hashes.json: %s points at program %d, there are %d", hash, index, len(db.Programs))
+    }
+  }
+  return db, nil
+}
+
+// the entry for a ROM's sha1 (see config.ROMKey); ok is false if it isn't known
+func (db *Database) Lookup(sha1 string) (entry Entry, ok bool) {
+  sha1 = strings.ToLower(sha1)
+  index, ok := db.Hashes[sha1]
+  if !ok {
+    return Entry{}, false
+  }
+  program := db.Programs[index]
+  rom, ok := program.ROMs[sha1]
+  if !ok {
+    return Entry{}, false
+  }
+  entry = Entry{Title: program.Title, Authors: program.Authors, Keys: rom.Keys, Quirks: cpu.MODERN_QUIRKS, ClockSpeed: cpu.CLOCK_SPEED}
+  tickrate := rom.Tickrate
+  if len(rom.Platforms) > 0 {
+    entry.Platform = rom.Platforms[0]
+    if entry.Platform == "xochip" {
+      entry.Machine = cpu.XO_CHIP
+    }
+    platform := db.Platforms[entry.Platform]
+    quirks := PlatformQuirks{}
+    for name, on := range platform.Quirks {
+      quirks[name] = on
+    }
+    for name, on := range rom.QuirkyPlatforms[entry.Platform] {
+      quirks[name] = on
+    }
+    entry.Quirks = quirks.Quirks()
+    entry.Quirks.KeyPress = keyPressPlatforms[entry.Platform]
+    if tickrate == 0 {
+      tickrate = platform.DefaultTickrate
+    }
+  }
+  if tickrate > 0 {
+    entry.ClockSpeed = ClockSpeed(tickrate)
+  }
+  if rom.Colors != nil {
+    entry.Palette = palette(rom.Colors.Pixels)
+  }
+  return entry, true
+}
+
+// The database's quirks as ours. Those we don't emulate (wrap, vblank) are
+// dropped, and memoryIncrementByX is close enough to incrementing I to count.
+func (q PlatformQuirks) Quirks() cpu.Quirks {
+  return cpu.Quirks{
+    ResetVF: q["logic"],
+    ShiftVY: !q["shift"],
+    JumpVX: q["jump"],
+    IncrementI: !q["memoryLeaveIUnchanged"],
+  }
+}
+
+// instructions per frame to per second, capped at what a uint16 holds
+func ClockSpeed(tickrate int) uint16 {
+  speed := tickrate * int(cpu.DELAY_SOUND_TIMER_UPDATE)
+  if speed > 0xFFFF {
+    return 0xFFFF - 0xFFFF % cpu.DELAY_SOUND_TIMER_UPDATE
+  }
+  return uint16(speed)
+}
+
+// the first 4 colours, or 2 if there aren't 4, as a screen.ParsePalette spec
+func palette(pixels []string) string {
+  switch {
+  case len(pixels) >= 4:
+    pixels = pixels[:4]
+  case len(pixels) >= 2:
+    pixels = pixels[:2]
+  default:
+    return ""
+  }
+  return strings.Join(pixels, ",")
+}
+
+// e.g. "up 5, a 6", sorted so it reads the same every run
+func (e Entry) KeyHints() string {
+  names := make([]string, 0, len(e.Keys))
+  for name := range e.Keys {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  hints := make([]string, len(names))
+  for index, name := range names {
+    hints[index] = fmt.Sprintf("%s %X", name, e.Keys[name])
+  }
+  return strings.Join(hints, ", ")
+}
@@ -0,0 +1,120 @@
+package romdb
+
+import (
+  "crypto/sha1"
+  "encoding/hex"
+  "os"
+  "path/filepath"
+  "testing"
+
+  "jfeintzeig/chip8/internal/cpu"
+)
+
+func TestBundled(t *testing.T) {
+  db, err := Bundled()
+  if err != nil {
+    t.Fatal(err)
+  }
+  for _, platform := range []string{"originalChip8", "modernChip8", "superchip", "xochip"} {
+    if _, ok := db.Platforms[platform]; !ok {
+      t.Errorf("no %s platform", platform)
+    }
+  }
+  ibm, err := os.ReadFile(filepath.Join("..", "cpu", "testdata", "roms", "ibm_logo.ch8"))
+  if err != nil {
+    t.Fatal(err)
+  }
+  sum := sha1.Sum(ibm)
+  entry, ok := db.Lookup(hex.EncodeToString(sum[:]))
+  if !ok {
+    t.Fatal("ibm_logo.ch8 isn't in the bundled database")
+  }
+  if entry.Title != "IBM Logo" || entry.Quirks != cpu.LEGACY_QUIRKS || entry.ClockSpeed != 900 {
+    t.Errorf("got %q with quirks %+v at %d Hz", entry.Title, entry.Quirks, entry.ClockSpeed)
+  }
+
+  // whatever's in it, every ROM has to be on a platform it knows
+  for hash := range db.Hashes {
+    entry, ok := db.Lookup(hash)
+    if !ok {
+      t.Errorf("%s is in sha1-hashes.json but not its program", hash)
+      continue
+    }
+    if _, ok := db.Platforms[entry.Platform]; entry.Platform != "" && !ok {
+      t.Errorf("%s (%s) is for unknown platform %q", entry.Title, hash, entry.Platform)
+    }
+  }
+}
+
+func TestTestdata(t *testing.T) {
+  db, err := Load("testdata")
+  if err != nil {
+    t.Fatal(err)
+  }
+  // internal/cpu/testdata/roms/bcd.ch8
+  entry, ok := db.Lookup("6BCE4C62B095431CBB4517D27D4C92CA89F2D08C")
+  if !ok {
+    t.Fatal("bcd.ch8 isn't in testdata")
+  }
+  if entry.Title != "BCD test" || entry.Platform != "originalChip8" {
+    t.Errorf("got %q on %q", entry.Title, entry.Platform)
+  }
+  if entry.Quirks != cpu.LEGACY_QUIRKS {
+    t.Errorf("got quirks %+v, want the COSMAC VIP's", entry.Quirks)
+  }
+  if entry.ClockSpeed != 600 {
+    t.Errorf("got %d Hz, want 10 instructions a frame", entry.ClockSpeed)
+  }
+  if entry.Palette != "#1A0F00,#FFB000" {
+    t.Errorf("got palette %q", entry.Palette)
+  }
+  if _, ok := db.Lookup("0000000000000000000000000000000000000000"); ok {
+    t.Error("found an unknown ROM")
+  }
+}
+
+func TestLoad(t *testing.T) {
+  dir := t.TempDir()
+  files := map[string]string{
+    "platforms.json": `[{"id": "superchip", "defaultTickrate": 30,
+      "quirks": {"shift": true, "memoryLeaveIUnchanged": true, "jump": true}}]`,
+    "programs.json": `[{"title": "Game", "roms": {"abc": {"platforms": ["superchip"],
+      "quirkyPlatforms": {"superchip": {"jump": false}}, "keys": {"up": 5, "a": 10},
+      "colors": {"pixels": ["#000000", "#111111", "#222222"]}}}}]`,
+    "sha1-hashes.json": `{"abc": 0}`,
+  }
+  for name, contents := range files {
+    if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+      t.Fatal(err)
+    }
+  }
+  db, err := Load(dir)
+  if err != nil {
+    t.Fatal(err)
+  }
+  entry, ok := db.Lookup("abc")
+  if !ok {
+    t.Fatal("abc not found")
+  }
+  // the ROM turns off the platform's jump quirk
+  want := cpu.Quirks{KeyPress: true}
+  if entry.Quirks != want {
+    t.Errorf("got quirks %+v, want %+v", entry.Quirks, want)
+  }
+  if entry.ClockSpeed != 1800 {
+    t.Errorf("got %d Hz, want the platform's 30 instructions a frame", entry.ClockSpeed)
+  }
+  if entry.Palette != "#000000,#111111" {
+    t.Errorf("got palette %q, want the first 2 of 3 colours", entry.Palette)
+  }
+  if hints := entry.KeyHints(); hints != "a A, up 5" {
+    t.Errorf("got key hints %q", hints)
+  }
+
+  if err := os.WriteFile(filepath.Join(dir, "sha1-hashes.json"), []byte(`{"abc": 1}`), 0644); err != nil {
+    t.Fatal(err)
+  }
+  if _, err := Load(dir); err == nil {
+    t.Error("a hash pointing past the programs should be an error")
+  }
+}
@@ -0,0 +1,49 @@
+package screen
+
+import (
+  "strings"
+)
+
+// for text front ends: each character cell shows 2 pixels stacked
+// vertically, indexed by top | bottom << 1
+var halfBlocks = []rune{' ', '▀', '▄', '█'}
+
+// each character cell shows 2x2 pixels, indexed by
+// top left | top right << 1 | bottom left << 2 | bottom right << 3
+var quarterBlocks = []rune{' ', '▘', '▝', '▀', '▖', '▌', '▞', '▛', '▗', '▚', '▐', '▜', '▄', '▙', '▟', '█'}
+
+// lit pixel at x, y, treating anything off the edge as unlit
+func (f Frame) lit(x int, y int) int {
+  if x >= f.Width || y >= f.Height || f.At(x, y) == 0 {
+    return 0
+  }
+  return 1
+}
+
+// one string per 2 rows of pixels, using half block characters, so the
+// 64x32 display fits in 64x16 characters
+func (f Frame) HalfBlocks() []string {
+  lines := []string{}
+  for y := 0; y < f.Height; y += 2 {
+    var sb strings.Builder
+    for x := 0; x < f.Width; x++ {
+      sb.WriteRune(halfBlocks[f.lit(x, y) | f.lit(x, y+1) << 1])
+    }
+    lines = append(lines, sb.String())
+  }
+  return lines
+}
+
+// one string per 2 rows of pixels, using quadrant characters, so even a
+// 128x64 display fits in 64x32 characters
+func (f Frame) QuarterBlocks() []string {
+  lines := []string{}
+  for y := 0; y < f.Height; y += 2 {
+    var sb strings.Builder
+    for x := 0; x < f.Width; x += 2 {
+      sb.WriteRune(quarterBlocks[f.lit(x, y) | f.lit(x+1, y) << 1 | f.lit(x, y+1) << 2 | f.lit(x+1, y+1) << 3])
+    }
+    lines = append(lines, sb.String())
+  }
+  return lines
+}
@@ -0,0 +1,19 @@
+package screen
+
+import (
+  "reflect"
+  "testing"
+)
+
+func TestBlocks(t *testing.T) {
+  // 4x2:
+  //   #.##
+  //   .###
+  f := Frame{[]uint8{1, 0, 1, 1, 0, 1, 1, 1}, 4, 2}
+  if got := f.HalfBlocks(); !reflect.DeepEqual(got, []string{"▀▄██"}) {
+    t.Errorf("half blocks: got %q", got)
+  }
+  if got := f.QuarterBlocks(); !reflect.DeepEqual(got, []string{"▚█"}) {
+    t.Errorf("quarter blocks: got %q", got)
+  }
+}
@@ -0,0 +1,250 @@
+package screen
+
+import (
+  "bytes"
+  "encoding/binary"
+  "errors"
+  "fmt"
+  "hash/crc32"
+  "image"
+  "image/gif"
+  "image/png"
+  "io"
+  "os"
+  "path/filepath"
+  "strings"
+  "time"
+)
+
+// frames per second of the recordings, the rate the Chip8 timers run at
+const RECORDING_FPS = 60
+// the longest a frame can be held for in ticks: APNG delays are a uint16 count
+// of ticks, about 18 minutes, and GIF ones a uint16 of hundredths of a second,
+// less one for the rounding carried between frames
+const MAX_APNG_TICKS = 0xFFFF
+const MAX_GIF_TICKS = (0xFFFF - 1) * RECORDING_FPS / 100
+
+// a name like chip8-20060102-150405-000.<extension>, down to the millisecond,
+// with -2, -3 and so on added if that file is already there
+func CaptureName(extension string) string {
+  name := "chip8-" + strings.Replace(time.Now().Format("20060102-150405.000"), ".", "-", 1)
+  path := name + "." + extension
+  for count := 2; ; count++ {
+    if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+      return path
+    }
+    path = fmt.Sprintf("%s-%d.%s", name, count, extension)
+  }
+}
+
+func SaveScreenshot(path string, f Frame, scale int, palette Palette) error {
+  file, err := os.Create(path)
+  if err != nil {
+    return err
+  }
+  defer file.Close()
+  return f.WritePNGWithPalette(file, scale, palette)
+}
+
+// collects frames, one per 60Hz tick, and encodes them as an animated GIF or APNG
+type Recorder struct {
+  scale int
+  palette Palette
+  frames []Frame
+  // how many ticks each frame is shown for, since repeated frames are merged
+  durations []int
+}
+
+func NewRecorder(scale int, palette Palette) *Recorder {
+  return &Recorder{scale: scale, palette: palette}
+}
+
+// call once per 60Hz tick, even when the screen hasn't changed
+func (r *Recorder) AddFrame(f Frame) {
+  last := len(r.frames) - 1
+  if last >= 0 && bytes.Equal(r.frames[last].Pixels, f.Pixels) && r.frames[last].Width == f.Width {
+    r.durations[last]++
+    return
+  }
+  r.frames = append(r.frames, f)
+  r.durations = append(r.durations, 1)
+}
+
+// so a Recorder can be attached to a cpu.Chip8 as its video sink
+func (r *Recorder) DrawFrame(pixels []uint8, width int, height int) {
+  r.AddFrame(NewFrame(pixels, width, height))
+}
+
+// distinct frames, after merging repeats
+func (r *Recorder) Frames() int {
+  return len(r.frames)
+}
+
+// 60Hz ticks recorded, counting repeats, which is how long the recording lasts
+func (r *Recorder) Ticks() int {
+  ticks := 0
+  for _, duration := range r.durations {
+    ticks += duration
+  }
+  return ticks
+}
+
+// writes a .gif, or an APNG for .png or .apng
+func (r *Recorder) Save(path string) error {
+  file, err := os.Create(path)
+  if err != nil {
+    return err
+  }
+  defer file.Close()
+  switch strings.ToLower(filepath.Ext(path)) {
+  case ".gif":
+    return r.WriteGIF(file)
+  case ".png", ".apng":
+    return r.WriteAPNG(file)
+  }
+  return fmt.Errorf("can't record to %s, use .gif, .png or .apng", path)
+}
+
+// the frame index and ticks of each frame to write, with holds longer than
+// maxTicks split into repeats of the frame
+func (r *Recorder) holds(maxTicks int) ([]int, []int) {
+  frames, durations := []int{}, []int{}
+  for index, duration := range r.durations {
+    for ; duration > maxTicks; duration -= maxTicks {
+      frames, durations = append(frames, index), append(durations, maxTicks)
+    }
+    frames, durations = append(frames, index), append(durations, duration)
+  }
+  return frames, durations
+}
+
+func (r *Recorder) WriteGIF(w io.Writer) error {
+  animation := &gif.GIF{}
+  // GIF delays are in hundredths of a second, so carry the rounding over
+  // to the next frame to keep the whole animation at 60Hz
+  elapsed := 0
+  frames, durations := r.holds(MAX_GIF_TICKS)
+  for hold, index := range frames {
+    f, ticks := r.frames[index], durations[hold]
+    end := (elapsed + ticks) * 100 / RECORDING_FPS
+    delay := end - elapsed * 100 / RECORDING_FPS
+    elapsed += ticks
+    animation.Image = append(animation.Image, f.ImageWithPalette(r.scale, r.palette))
+    animation.Delay = append(animation.Delay, delay)
+  }
+  return gif.EncodeAll(w, animation)
+}
+
+func writeChunk(w io.Writer, chunkType string, data []byte) error {
+  header := make([]byte, 8)
+  binary.BigEndian.PutUint32(header, uint32(len(data)))
+  copy(header[4:], chunkType)
+  crc := crc32.NewIEEE()
+  crc.Write(header[4:])
+  crc.Write(data)
+  footer := make([]byte, 4)
+  binary.BigEndian.PutUint32(footer, crc.Sum32())
+  for _, part := range [][]byte{header, data, footer} {
+    if _, err := w.Write(part); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+type pngChunk struct {
+  chunkType string
+  data []byte
+}
+
+// splits an encoded PNG into its chunks, skipping the signature
+func readChunks(encoded []byte) []pngChunk {
+  chunks := []pngChunk{}
+  for offset := 8; offset + 12 <= len(encoded); {
+    length := int(binary.BigEndian.Uint32(encoded[offset:]))
+    chunks = append(chunks, pngChunk{string(encoded[offset+4:offset+8]), encoded[offset+8:offset+8+length]})
+    offset += 12 + length
+  }
+  return chunks
+}
+
+// image/png can't write animations, so this encodes each frame as a still
+// PNG and rewraps the image data in APNG's fcTL/fdAT chunks. Every frame has
+// the same palette and size, so they all share the first frame's header.
+func (r *Recorder) WriteAPNG(w io.Writer) error {
+  if len(r.frames) == 0 {
+    return fmt.Errorf("no frames recorded")
+  }
+  if _, err := w.Write([]byte("\x89PNG\r\n\x1a\n")); err != nil {
+    return err
+  }
+
+  sequence := uint32(0)
+  frames, durations := r.holds(MAX_APNG_TICKS)
+  var img image.Image
+  var chunks []pngChunk
+  for hold, index := range frames {
+    // a split hold repeats the frame, which only needs encoding once
+    if hold == 0 || frames[hold-1] != index {
+      var encoded bytes.Buffer
+      img = r.frames[index].ImageWithPalette(r.scale, r.palette)
+      if err := png.Encode(&encoded, img); err != nil {
+        return err
+      }
+      chunks = readChunks(encoded.Bytes())
+    }
+
+    if hold == 0 {
+      // everything before the image data: IHDR, PLTE, tRNS
+      for _, chunk := range chunks {
+        if chunk.chunkType == "IDAT" {
+          break
+        }
+        if err := writeChunk(w, chunk.chunkType, chunk.data); err != nil {
+          return err
+        }
+        if chunk.chunkType == "IHDR" {
+          // acTL: number of frames, then 0 to loop forever
+          animationControl := make([]byte, 8)
+          binary.BigEndian.PutUint32(animationControl, uint32(len(frames)))
+          if err := writeChunk(w, "acTL", animationControl); err != nil {
+            return err
+          }
+        }
+      }
+    }
+
+    // fcTL: sequence, size, offset, delay as a fraction, dispose and blend ops
+    frameControl := make([]byte, 26)
+    binary.BigEndian.PutUint32(frameControl[0:], sequence)
+    binary.BigEndian.PutUint32(frameControl[4:], uint32(img.Bounds().Dx()))
+    binary.BigEndian.PutUint32(frameControl[8:], uint32(img.Bounds().Dy()))
+    binary.BigEndian.PutUint16(frameControl[20:], uint16(durations[hold]))
+    binary.BigEndian.PutUint16(frameControl[22:], RECORDING_FPS)
+    sequence++
+    if err := writeChunk(w, "fcTL", frameControl); err != nil {
+      return err
+    }
+
+    for _, chunk := range chunks {
+      if chunk.chunkType != "IDAT" {
+        continue
+      }
+      if hold == 0 {
+        // the first frame doubles as the still image for viewers without APNG support
+        if err := writeChunk(w, "IDAT", chunk.data); err != nil {
+          return err
+        }
+        continue
+      }
+      frameData := make([]byte, 4 + len(chunk.data))
+      binary.BigEndian.PutUint32(frameData, sequence)
+      copy(frameData[4:], chunk.data)
+      sequence++
+      if err := writeChunk(w, "fdAT", frameData); err != nil {
+        return err
+      }
+    }
+  }
+  return writeChunk(w, "IEND", nil)
+}
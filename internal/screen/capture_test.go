@@ -0,0 +1,117 @@
+package screen
+
+import (
+  "bytes"
+  "encoding/binary"
+  "image/gif"
+  "os"
+  "testing"
+)
+
+func testRecorder() *Recorder {
+  r := NewRecorder(2, CLASSIC)
+  for tick := 0; tick < 60; tick++ {
+    // the pixel moves every 20 ticks, so 3 distinct frames
+    pixels := make([]uint8, 4)
+    pixels[tick/20] = 1
+    r.AddFrame(Frame{pixels, 2, 2})
+  }
+  return r
+}
+
+func TestRecorderGIF(t *testing.T) {
+  var out bytes.Buffer
+  if err := testRecorder().WriteGIF(&out); err != nil {
+    t.Fatal(err)
+  }
+  animation, err := gif.DecodeAll(&out)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(animation.Image) != 3 {
+    t.Fatalf("got %d frames, want 3", len(animation.Image))
+  }
+  total := 0
+  for _, delay := range animation.Delay {
+    total += delay
+  }
+  if total != 100 {
+    t.Errorf("60 ticks lasted %d hundredths of a second, want 100", total)
+  }
+}
+
+func TestRecorderAPNG(t *testing.T) {
+  var out bytes.Buffer
+  if err := testRecorder().WriteAPNG(&out); err != nil {
+    t.Fatal(err)
+  }
+  counts := map[string]int{}
+  for _, chunk := range readChunks(out.Bytes()) {
+    counts[chunk.chunkType]++
+  }
+  if counts["acTL"] != 1 || counts["fcTL"] != 3 || counts["IDAT"] < 1 || counts["fdAT"] < 2 || counts["IEND"] != 1 {
+    t.Errorf("unexpected chunks %v", counts)
+  }
+}
+
+// a frame held for longer than a delay can say is split into repeats of it
+func TestRecorderLongHold(t *testing.T) {
+  r := NewRecorder(1, CLASSIC)
+  r.AddFrame(Frame{[]uint8{1}, 1, 1})
+  r.durations[0] = MAX_APNG_TICKS + 5
+
+  var out bytes.Buffer
+  if err := r.WriteAPNG(&out); err != nil {
+    t.Fatal(err)
+  }
+  delays := []uint16{}
+  for _, chunk := range readChunks(out.Bytes()) {
+    switch chunk.chunkType {
+    case "acTL":
+      if frames := binary.BigEndian.Uint32(chunk.data); frames != 2 {
+        t.Errorf("acTL says %d frames, want 2", frames)
+      }
+    case "fcTL":
+      delays = append(delays, binary.BigEndian.Uint16(chunk.data[20:]))
+    }
+  }
+  if len(delays) != 2 || delays[0] != MAX_APNG_TICKS || delays[1] != 5 {
+    t.Errorf("got APNG delays %v", delays)
+  }
+
+  out.Reset()
+  if err := r.WriteGIF(&out); err != nil {
+    t.Fatal(err)
+  }
+  animation, err := gif.DecodeAll(&out)
+  if err != nil {
+    t.Fatal(err)
+  }
+  total := 0
+  for _, delay := range animation.Delay {
+    total += delay
+  }
+  if want := r.durations[0] * 100 / RECORDING_FPS; total != want {
+    t.Errorf("GIF lasted %d hundredths of a second, want %d", total, want)
+  }
+}
+
+func TestCaptureName(t *testing.T) {
+  dir, err := os.Getwd()
+  if err != nil {
+    t.Fatal(err)
+  }
+  if err := os.Chdir(t.TempDir()); err != nil {
+    t.Fatal(err)
+  }
+  defer os.Chdir(dir)
+
+  // taken in the same millisecond, or near enough
+  first := CaptureName("png")
+  if err := os.WriteFile(first, nil, 0644); err != nil {
+    t.Fatal(err)
+  }
+  if second := CaptureName("png"); second == first {
+    t.Errorf("got %s twice", first)
+  }
+}
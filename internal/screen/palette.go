@@ -0,0 +1,73 @@
+package screen
+
+import (
+  "encoding/hex"
+  "fmt"
+  "image/color"
+  "strings"
+)
+
+// colours for each pixel value: background, then XO-CHIP bitplane 1,
+// bitplane 2, and both planes. Plain Chip8 only uses the first two.
+type Palette [4]color.RGBA
+
+func rgb(value uint32) color.RGBA {
+  return color.RGBA{uint8(value >> 16), uint8(value >> 8), uint8(value), 0xFF}
+}
+
+var PALETTES = map[string]Palette{
+  "classic": {rgb(0x000000), rgb(0xFFFFFF), rgb(0xAAAAAA), rgb(0x555555)},
+  "amber": {rgb(0x1A0F00), rgb(0xFFB000), rgb(0xCC7A00), rgb(0x664000)},
+  "green": {rgb(0x0A1A0A), rgb(0x33FF33), rgb(0x1FB31F), rgb(0x0F590F)},
+  "lcd": {rgb(0x9BBC0F), rgb(0x0F380F), rgb(0x306230), rgb(0x5A7D1A)},
+  "high-contrast": {rgb(0x000000), rgb(0xFFFF00), rgb(0x00FFFF), rgb(0xFF00FF)},
+}
+
+// the order the palette hotkey cycles through
+var PALETTE_NAMES = []string{"classic", "amber", "green", "lcd", "high-contrast"}
+
+var CLASSIC = PALETTES["classic"]
+
+// a palette name, or 2 or 4 comma separated hex colours, e.g. "#000000,#FF8800".
+// With 2 colours the XO-CHIP planes reuse the foreground.
+func ParsePalette(spec string) (Palette, error) {
+  if palette, ok := PALETTES[spec]; ok {
+    return palette, nil
+  }
+  colours := strings.Split(spec, ",")
+  if len(colours) != 2 && len(colours) != 4 {
+    return Palette{}, fmt.Errorf("unknown palette %q, want one of %s or 2 or 4 hex colours", spec, strings.Join(PALETTE_NAMES, ", "))
+  }
+  palette := Palette{}
+  for index, colour := range colours {
+    value, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(colour), "#"))
+    if err != nil || len(value) != 3 {
+      return Palette{}, fmt.Errorf("bad colour %q in palette, want #RRGGBB", colour)
+    }
+    palette[index] = color.RGBA{value[0], value[1], value[2], 0xFF}
+  }
+  if len(colours) == 2 {
+    palette[2] = palette[1]
+    palette[3] = palette[1]
+  }
+  return palette, nil
+}
+
+// the palette after name in PALETTE_NAMES, wrapping around; user defined
+// palettes aren't in the list so they go back to the start
+func NextPalette(name string) string {
+  for index, candidate := range PALETTE_NAMES {
+    if candidate == name {
+      return PALETTE_NAMES[(index + 1) % len(PALETTE_NAMES)]
+    }
+  }
+  return PALETTE_NAMES[0]
+}
+
+func (p Palette) Color(value uint8) color.RGBA {
+  return p[value & 0x3]
+}
+
+func (p Palette) colorPalette() color.Palette {
+  return color.Palette{p[0], p[1], p[2], p[3]}
+}
@@ -0,0 +1,63 @@
+package screen
+
+import (
+  "image/color"
+  "testing"
+)
+
+func TestParsePalette(t *testing.T) {
+  if palette, err := ParsePalette("amber"); err != nil || palette != PALETTES["amber"] {
+    t.Errorf("amber: got %v, %v", palette, err)
+  }
+
+  palette, err := ParsePalette("#000000,#FF8800")
+  if err != nil {
+    t.Fatal(err)
+  }
+  orange := color.RGBA{0xFF, 0x88, 0x00, 0xFF}
+  if palette[0] != (color.RGBA{0, 0, 0, 0xFF}) || palette[1] != orange || palette[2] != orange || palette[3] != orange {
+    t.Errorf("two colours: got %v", palette)
+  }
+
+  for _, bad := range []string{"sepia", "#000000", "#000000,#FF88", "#000000,#GG0000", "#000000,#111111,#222222"} {
+    if _, err := ParsePalette(bad); err == nil {
+      t.Errorf("%q should be rejected", bad)
+    }
+  }
+}
+
+func TestNextPalette(t *testing.T) {
+  if next := NextPalette("classic"); next != "amber" {
+    t.Errorf("after classic got %s", next)
+  }
+  if next := NextPalette(PALETTE_NAMES[len(PALETTE_NAMES)-1]); next != PALETTE_NAMES[0] {
+    t.Errorf("last palette should wrap around, got %s", next)
+  }
+  if next := NextPalette("#000000,#FFFFFF"); next != PALETTE_NAMES[0] {
+    t.Errorf("custom palette should go back to the start, got %s", next)
+  }
+}
+
+// every colour in a built-in palette has to stand out from the others
+func TestPalettesDistinct(t *testing.T) {
+  distance := func(a color.RGBA, b color.RGBA) int {
+    total := 0
+    for _, pair := range [][2]uint8{{a.R, b.R}, {a.G, b.G}, {a.B, b.B}} {
+      if pair[0] > pair[1] {
+        total += int(pair[0] - pair[1])
+      } else {
+        total += int(pair[1] - pair[0])
+      }
+    }
+    return total
+  }
+  for name, palette := range PALETTES {
+    for i := range palette {
+      for j := i + 1; j < len(palette); j++ {
+        if d := distance(palette[i], palette[j]); d < 64 {
+          t.Errorf("%s: colours %d and %d are only %d apart", name, i, j, d)
+        }
+      }
+    }
+  }
+}
@@ -0,0 +1,127 @@
+package screen
+
+import (
+  "fmt"
+  "image/color"
+)
+
+// Chip8 games erase and redraw sprites with XOR every frame, so anything that
+// moves flickers. A persistence filter smooths that out by remembering recent frames.
+type PersistenceMode int
+
+const (
+  // show each frame as it is
+  NO_PERSISTENCE PersistenceMode = iota
+  // lit pixels fade out over a few frames, like the phosphor on an old CRT
+  DECAY
+  // a pixel is lit if it was lit in any of the last few frames
+  OR_FRAMES
+)
+
+func ParsePersistenceMode(name string) (PersistenceMode, error) {
+  switch name {
+  case "none", "":
+    return NO_PERSISTENCE, nil
+  case "decay":
+    return DECAY, nil
+  case "or":
+    return OR_FRAMES, nil
+  }
+  return NO_PERSISTENCE, fmt.Errorf("unknown persistence mode %q, want none, decay or or", name)
+}
+
+type Persistence struct {
+  mode PersistenceMode
+  // fraction of its brightness a pixel keeps each frame after it goes dark, for DECAY
+  decay float64
+  // how many frames to OR together, for OR_FRAMES
+  frames int
+  // per pixel: how lit it is from 0 to 1, and the value it was last lit with
+  brightness []float64
+  value []uint8
+  // the last frames, oldest first, for OR_FRAMES
+  history []Frame
+}
+
+// decay has to be from 0 to 1: more would brighten pixels past their colour
+// and less would flip them between lit and dark
+func NewPersistence(mode PersistenceMode, decay float64, frames int) (*Persistence, error) {
+  if !(decay >= 0 && decay <= 1) {
+    return nil, fmt.Errorf("decay %v is outside 0 to 1", decay)
+  }
+  if frames < 1 {
+    frames = 1
+  }
+  return &Persistence{mode: mode, decay: decay, frames: frames}, nil
+}
+
+// feeds in the next 60Hz frame; call it once per frame, not once per draw.
+// f is kept for OR_FRAMES, so it should be a copy, e.g. from NewFrame.
+func (p *Persistence) Update(f Frame) {
+  if len(p.value) != len(f.Pixels) {
+    // first frame, or the resolution changed: start over
+    p.brightness = make([]float64, len(f.Pixels))
+    p.value = make([]uint8, len(f.Pixels))
+    p.history = nil
+  }
+
+  switch p.mode {
+  case DECAY:
+    for index, pixel := range f.Pixels {
+      if pixel != 0 {
+        p.brightness[index] = 1
+        p.value[index] = pixel
+      } else {
+        p.brightness[index] *= p.decay
+      }
+    }
+  case OR_FRAMES:
+    p.history = append(p.history, f)
+    if len(p.history) > p.frames {
+      p.history = p.history[1:]
+    }
+    for index := range p.value {
+      p.value[index] = 0
+      for _, old := range p.history {
+        p.value[index] |= old.Pixels[index]
+      }
+      p.brightness[index] = 0
+      if p.value[index] != 0 {
+        p.brightness[index] = 1
+      }
+    }
+  default:
+    for index, pixel := range f.Pixels {
+      p.value[index] = pixel
+      p.brightness[index] = 0
+      if pixel != 0 {
+        p.brightness[index] = 1
+      }
+    }
+  }
+}
+
+// the colour to show pixel index in: its last colour, faded towards the background
+func (p *Persistence) Color(index int, palette Palette) color.RGBA {
+  background := palette.Color(0)
+  if index >= len(p.value) || p.brightness[index] <= 0 {
+    return background
+  }
+  foreground := palette.Color(p.value[index])
+  mix := func(from uint8, to uint8) uint8 {
+    return uint8(float64(from) + (float64(to) - float64(from))*p.brightness[index] + 0.5)
+  }
+  return color.RGBA{mix(background.R, foreground.R), mix(background.G, foreground.G), mix(background.B, foreground.B), 0xFF}
+}
+
+// fills dst, 4 bytes per pixel row by row, with every pixel's colour; the
+// layout ebiten's WritePixels and image.RGBA use
+func (p *Persistence) WriteRGBA(dst []byte, palette Palette) {
+  for index := 0; index < len(p.value) && index*4 + 3 < len(dst); index++ {
+    colour := p.Color(index, palette)
+    dst[index*4] = colour.R
+    dst[index*4+1] = colour.G
+    dst[index*4+2] = colour.B
+    dst[index*4+3] = colour.A
+  }
+}
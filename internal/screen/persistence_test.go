@@ -0,0 +1,66 @@
+package screen
+
+import (
+  "math"
+  "testing"
+)
+
+func newPersistence(t testing.TB, mode PersistenceMode, decay float64, frames int) *Persistence {
+  p, err := NewPersistence(mode, decay, frames)
+  if err != nil {
+    t.Fatal(err)
+  }
+  return p
+}
+
+func TestDecay(t *testing.T) {
+  p := newPersistence(t, DECAY, 0.5, 0)
+  p.Update(Frame{[]uint8{1, 0}, 2, 1})
+  p.Update(Frame{[]uint8{0, 0}, 2, 1})
+  if got := p.Color(0, CLASSIC); got.R != 128 {
+    t.Errorf("one frame after going dark, got %v, want half brightness", got)
+  }
+  if got := p.Color(1, CLASSIC); got != CLASSIC[0] {
+    t.Errorf("never lit pixel got %v, want the background", got)
+  }
+}
+
+func TestDecayRange(t *testing.T) {
+  for _, decay := range []float64{0, 1} {
+    if _, err := NewPersistence(DECAY, decay, 0); err != nil {
+      t.Errorf("decay %v: %v", decay, err)
+    }
+  }
+  for _, decay := range []float64{-0.1, 1.5, math.NaN()} {
+    if _, err := NewPersistence(DECAY, decay, 0); err == nil {
+      t.Errorf("decay %v was accepted", decay)
+    }
+  }
+}
+
+func TestOrFrames(t *testing.T) {
+  p := newPersistence(t, OR_FRAMES, 0, 2)
+  p.Update(Frame{[]uint8{1, 0}, 2, 1})
+  p.Update(Frame{[]uint8{0, 1}, 2, 1})
+  if p.Color(0, CLASSIC) != CLASSIC[1] || p.Color(1, CLASSIC) != CLASSIC[1] {
+    t.Error("both pixels were lit in the last 2 frames")
+  }
+  p.Update(Frame{[]uint8{0, 0}, 2, 1})
+  if p.Color(0, CLASSIC) != CLASSIC[0] {
+    t.Error("pixel 0 was last lit 3 frames ago")
+  }
+}
+
+func BenchmarkWriteRGBA(b *testing.B) {
+  f := Frame{make([]uint8, 64*32), 64, 32}
+  for index := range f.Pixels {
+    f.Pixels[index] = uint8(index % 2)
+  }
+  p := newPersistence(b, DECAY, 0.5, 0)
+  p.Update(f)
+  dst := make([]byte, 4*len(f.Pixels))
+  b.ResetTimer()
+  for n := 0; n < b.N; n++ {
+    p.WriteRGBA(dst, CLASSIC)
+  }
+}
@@ -0,0 +1,71 @@
+package screen
+
+import (
+  "crypto/sha1"
+  "encoding/hex"
+  "image"
+  "image/png"
+  "io"
+  "strings"
+)
+
+// a snapshot of a Chip8 framebuffer, independent of any UI framework:
+// one byte per pixel, row by row, non-zero means lit.
+type Frame struct {
+  Pixels []uint8
+  Width int
+  Height int
+}
+
+func NewFrame(pixels []uint8, width int, height int) Frame {
+  return Frame{append([]uint8{}, pixels...), width, height}
+}
+
+func (f Frame) At(x int, y int) uint8 {
+  return f.Pixels[y*f.Width + x]
+}
+
+// renders the frame white on black, each Chip8 pixel as a scale x scale square
+func (f Frame) Image(scale int) *image.Paletted {
+  return f.ImageWithPalette(scale, CLASSIC)
+}
+
+func (f Frame) ImageWithPalette(scale int, palette Palette) *image.Paletted {
+  img := image.NewPaletted(image.Rect(0, 0, f.Width*scale, f.Height*scale), palette.colorPalette())
+  for y := 0; y < f.Height*scale; y++ {
+    for x := 0; x < f.Width*scale; x++ {
+      img.SetColorIndex(x, y, f.At(x/scale, y/scale) & 0x3)
+    }
+  }
+  return img
+}
+
+func (f Frame) WritePNG(w io.Writer, scale int) error {
+  return png.Encode(w, f.Image(scale))
+}
+
+func (f Frame) WritePNGWithPalette(w io.Writer, scale int, palette Palette) error {
+  return png.Encode(w, f.ImageWithPalette(scale, palette))
+}
+
+// one line per row, '#' for lit pixels and '.' for unlit ones
+func (f Frame) ASCII() string {
+  var sb strings.Builder
+  for y := 0; y < f.Height; y++ {
+    for x := 0; x < f.Width; x++ {
+      if f.At(x, y) != 0 {
+        sb.WriteByte('#')
+      } else {
+        sb.WriteByte('.')
+      }
+    }
+    sb.WriteByte('\n')
+  }
+  return sb.String()
+}
+
+// sha1 of the pixels, handy for comparing frames in tests and CI
+func (f Frame) Hash() string {
+  sum := sha1.Sum(f.Pixels)
+  return hex.EncodeToString(sum[:])
+}
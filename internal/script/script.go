@@ -0,0 +1,123 @@
+package script
+
+import (
+  "bufio"
+  "fmt"
+  "io"
+  "os"
+  "sort"
+  "strconv"
+  "strings"
+
+  "jfeintzeig/chip8/internal/cpu"
+)
+
+// a key going down or up at the start of a given frame
+type Event struct {
+  Frame int
+  Key uint8
+  Pressed bool
+}
+
+// scripted keypad input for headless runs, sorted by frame
+type Script []Event
+
+// parses one "<frame> <key> <down|up>" event per line, key in hex, e.g.
+//     30 5 down
+//     34 5 up
+// blank lines and lines starting with # are ignored
+func Parse(r io.Reader) (Script, error) {
+  s := Script{}
+  scanner := bufio.NewScanner(r)
+  lineNumber := 0
+  for scanner.Scan() {
+    lineNumber++
+    text := strings.TrimSpace(scanner.Text())
+    if text == "" || strings.HasPrefix(text, "#") {
+      continue
+    }
+    fields := strings.Fields(text)
+    if len(fields) != 3 {
+      return nil, fmt.Errorf("line %d: expected \"<frame> <key> <down|up>\"", lineNumber)
+    }
+    frame, err := strconv.Atoi(fields[0])
+    if err != nil || frame < 0 {
+      return nil, fmt.Errorf("line %d: bad frame %q", lineNumber, fields[0])
+    }
+    key, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(fields[1]), "0x"), 16, 8)
+    if err != nil || key > 0xF {
+      return nil, fmt.Errorf("line %d: bad key %q", lineNumber, fields[1])
+    }
+    var pressed bool
+    switch fields[2] {
+    case "down":
+      pressed = true
+    case "up":
+      pressed = false
+    default:
+      return nil, fmt.Errorf("line %d: expected down or up, got %q", lineNumber, fields[2])
+    }
+    s = append(s, Event{frame, uint8(key), pressed})
+  }
+  if err := scanner.Err(); err != nil {
+    return nil, err
+  }
+  sort.SliceStable(s, func(i, j int) bool { return s[i].Frame < s[j].Frame })
+  return s, nil
+}
+
+func Load(path string) (Script, error) {
+  file, err := os.Open(path)
+  if err != nil {
+    return nil, err
+  }
+  defer file.Close()
+  return Parse(file)
+}
+
+// updates the keypad for the start of frame: clears last frame's
+// JustReleased flags, then applies any events scheduled for this frame
+func (s Script) Apply(frame int, c8 *cpu.Chip8) {
+  for key := range c8.Keyboard {
+    c8.SetKey(uint8(key), c8.Keyboard[key].Pressed)
+  }
+  for _, event := range s {
+    if event.Frame == frame {
+      c8.SetKey(event.Key, event.Pressed)
+    }
+  }
+}
+
+// plays a script back as a cpu.EventSource, one frame per call, so a key
+// that goes down and up in the same frame is still pressed for a frame
+type Player struct {
+  script Script
+  frame int
+  keys [16]bool
+}
+
+func (s Script) Player() *Player {
+  return &Player{script: s}
+}
+
+func (p *Player) PollKeys(keys *[16]bool) {
+  for _, event := range p.script {
+    if event.Frame == p.frame {
+      p.keys[event.Key] = event.Pressed
+    }
+  }
+  *keys = p.keys
+  p.frame++
+}
+
+func (p *Player) KeyEvents(frame uint64) []cpu.KeyEvent {
+  events := []cpu.KeyEvent{}
+  for _, event := range p.script {
+    if event.Frame == p.frame {
+      p.keys[event.Key] = event.Pressed
+      events = append(events, cpu.KeyEvent{Key: event.Key, Pressed: event.Pressed, Frame: frame})
+    }
+  }
+  p.frame++
+  return events
+}
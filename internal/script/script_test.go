@@ -0,0 +1,70 @@
+package script
+
+import (
+  "reflect"
+  "strings"
+  "testing"
+
+  "jfeintzeig/chip8/internal/cpu"
+)
+
+func TestParse(t *testing.T) {
+  s, err := Parse(strings.NewReader(`
+# press 5, then tap A
+30 5 down
+34 5 up
+  12 0xa down
+12 A up
+`))
+  if err != nil {
+    t.Fatal(err)
+  }
+  // sorted by frame, keeping the order within one
+  want := Script{{12, 0xA, true}, {12, 0xA, false}, {30, 5, true}, {34, 5, false}}
+  if !reflect.DeepEqual(s, want) {
+    t.Errorf("got %v, want %v", s, want)
+  }
+
+  for _, bad := range []string{
+    "30 5",
+    "-1 5 down",
+    "x 5 down",
+    "30 10 down",
+    "30 g down",
+    "30 5 held",
+  } {
+    if _, err := Parse(strings.NewReader(bad)); err == nil {
+      t.Errorf("%q parsed", bad)
+    }
+  }
+}
+
+func TestPlayer(t *testing.T) {
+  s := Script{{1, 5, true}, {1, 5, false}, {2, 3, true}, {4, 3, false}}
+
+  player := s.Player()
+  keys := [16]bool{}
+  held := []bool{}
+  for frame := 0; frame < 5; frame++ {
+    player.PollKeys(&keys)
+    held = append(held, keys[3])
+    if keys[5] {
+      t.Errorf("frame %d: key 5 is held, but a tap only shows up as events", frame)
+    }
+  }
+  if !reflect.DeepEqual(held, []bool{false, false, true, true, false}) {
+    t.Errorf("key 3 held %v", held)
+  }
+
+  // the same frame's down and up both come through
+  player = s.Player()
+  player.KeyEvents(100)
+  events := player.KeyEvents(101)
+  want := []cpu.KeyEvent{{Key: 5, Pressed: true, Frame: 101}, {Key: 5, Pressed: false, Frame: 101}}
+  if !reflect.DeepEqual(events, want) {
+    t.Errorf("got %v, want %v", events, want)
+  }
+  if events := player.KeyEvents(102); len(events) != 1 || events[0].Key != 3 || !events[0].Pressed {
+    t.Errorf("got %v, want key 3 down", events)
+  }
+}
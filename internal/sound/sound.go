@@ -0,0 +1,172 @@
+package sound
+
+import (
+  "encoding/binary"
+  "fmt"
+  "math"
+  "sync"
+
+  "jfeintzeig/chip8/internal/cpu"
+)
+
+// samples per second of the generated audio
+const SAMPLE_RATE = 48000
+// bytes per sample: 16 bit little endian, left then right, the format ebiten's audio players take
+const BYTES_PER_SAMPLE = 4
+
+const DEFAULT_FREQUENCY = 440
+const DEFAULT_VOLUME = 0.25
+
+type Waveform int
+
+const (
+  SQUARE Waveform = iota
+  TRIANGLE
+  SINE
+  SAWTOOTH
+)
+
+var WAVEFORM_NAMES = []string{"square", "triangle", "sine", "sawtooth"}
+
+func ParseWaveform(name string) (Waveform, error) {
+  if name == "" {
+    return SQUARE, nil
+  }
+  for index, waveformName := range WAVEFORM_NAMES {
+    if name == waveformName {
+      return Waveform(index), nil
+    }
+  }
+  return SQUARE, fmt.Errorf("unknown waveform %q, want one of %v", name, WAVEFORM_NAMES)
+}
+
+// the waveform's value from -1 to 1 at phase, which goes from 0 to 1 over a cycle
+func (w Waveform) at(phase float64) float64 {
+  switch w {
+  case TRIANGLE:
+    return 1 - 4*math.Abs(phase - 0.5)
+  case SINE:
+    return math.Sin(2*math.Pi*phase)
+  case SAWTOOTH:
+    return 2*phase - 1
+  }
+  if phase < 0.5 {
+    return 1
+  }
+  return -1
+}
+
+// An endless stream of audio that plays a tone while the sound timer is
+// running and silence otherwise. Safe to switch on and off from the
+// emulator's goroutine while the audio player reads it from another.
+type Tone struct {
+  mu sync.Mutex
+  frequency float64
+  waveform Waveform
+  volume float64
+  on bool
+  muted bool
+  // where in the cycle we are, carried between reads so the tone doesn't click
+  phase float64
+  // XO-CHIP: once a ROM sets a pattern it plays instead of the waveform
+  hasPattern bool
+  pattern [cpu.AUDIO_PATTERN_SIZE]byte
+  // pattern samples per second
+  patternRate float64
+  // how far through the pattern we are is patternStart plus patternSamples
+  // output samples at patternRate; counting samples rather than adding up a
+  // fractional step keeps every bit exactly as long as it should be
+  patternStart float64
+  patternSamples uint64
+}
+
+// the rate XO-CHIP plays audio patterns at for a given pitch register
+func PatternRate(pitch uint8) float64 {
+  return 4000 * math.Pow(2, (float64(pitch) - 64) / 48)
+}
+
+// volume goes from 0 to 1
+func NewTone(frequency float64, waveform Waveform, volume float64) *Tone {
+  if frequency <= 0 {
+    frequency = DEFAULT_FREQUENCY
+  }
+  return &Tone{frequency: frequency, waveform: waveform, volume: math.Max(0, math.Min(volume, 1))}
+}
+
+// cpu.AudioSink
+func (t *Tone) SetTone(on bool) {
+  t.mu.Lock()
+  defer t.mu.Unlock()
+  t.on = on
+}
+
+// cpu.PatternSink
+func (t *Tone) SetPattern(pattern [cpu.AUDIO_PATTERN_SIZE]byte, pitch uint8) {
+  t.mu.Lock()
+  defer t.mu.Unlock()
+  rate := PatternRate(pitch)
+  if rate != t.patternRate {
+    // carry on from where we are at the new rate
+    t.patternStart = t.patternPosition()
+    t.patternSamples = 0
+    t.patternRate = rate
+  }
+  t.pattern = pattern
+  t.hasPattern = true
+}
+
+// in pattern samples, from 0 up to but not including the 128 in a pattern
+func (t *Tone) patternPosition() float64 {
+  return math.Mod(t.patternStart + float64(t.patternSamples) * t.patternRate / SAMPLE_RATE, cpu.AUDIO_PATTERN_SIZE*8)
+}
+
+// the next sample, from -1 to 1
+func (t *Tone) next() float64 {
+  if t.hasPattern {
+    position := int(t.patternPosition())
+    t.patternSamples++
+    if t.pattern[position/8] & (0x80 >> (position%8)) != 0 {
+      return 1
+    }
+    return -1
+  }
+  value := t.waveform.at(t.phase)
+  t.phase += t.frequency / SAMPLE_RATE
+  t.phase -= math.Floor(t.phase)
+  return value
+}
+
+// flips mute and returns whether it's now muted
+func (t *Tone) ToggleMute() bool {
+  t.mu.Lock()
+  defer t.mu.Unlock()
+  t.muted = !t.muted
+  return t.muted
+}
+
+func (t *Tone) SetMuted(muted bool) {
+  t.mu.Lock()
+  defer t.mu.Unlock()
+  t.muted = muted
+}
+
+// fills buf with whole samples and never runs out
+func (t *Tone) Read(buf []byte) (int, error) {
+  t.mu.Lock()
+  defer t.mu.Unlock()
+  samples := len(buf) / BYTES_PER_SAMPLE
+  for sample := 0; sample < samples; sample++ {
+    value := int16(0)
+    if t.on && !t.muted {
+      value = int16(t.next() * t.volume * math.MaxInt16)
+    } else {
+      // start the next beep at the beginning of a cycle
+      t.phase = 0
+      t.patternStart = 0
+      t.patternSamples = 0
+    }
+    binary.LittleEndian.PutUint16(buf[sample*BYTES_PER_SAMPLE:], uint16(value))
+    binary.LittleEndian.PutUint16(buf[sample*BYTES_PER_SAMPLE+2:], uint16(value))
+  }
+  return samples * BYTES_PER_SAMPLE, nil
+}
@@ -0,0 +1,61 @@
+package sound
+
+import (
+  "encoding/binary"
+  "testing"
+)
+
+// one second of the left channel
+func readSecond(t *testing.T, tone *Tone) []int16 {
+  buf := make([]byte, SAMPLE_RATE*BYTES_PER_SAMPLE)
+  n, err := tone.Read(buf)
+  if err != nil || n != len(buf) {
+    t.Fatalf("read %d bytes, %v", n, err)
+  }
+  samples := make([]int16, SAMPLE_RATE)
+  for index := range samples {
+    samples[index] = int16(binary.LittleEndian.Uint16(buf[index*BYTES_PER_SAMPLE:]))
+  }
+  return samples
+}
+
+func TestSilentUntilOn(t *testing.T) {
+  tone := NewTone(440, SQUARE, 0.5)
+  for _, sample := range readSecond(t, tone) {
+    if sample != 0 {
+      t.Fatal("sound before SetTone(true)")
+    }
+  }
+}
+
+func TestSquareWave(t *testing.T) {
+  tone := NewTone(440, SQUARE, 0.5)
+  tone.SetTone(true)
+  samples := readSecond(t, tone)
+  cycles := 0
+  for index := 1; index < len(samples); index++ {
+    if samples[index-1] < 0 && samples[index] > 0 {
+      cycles++
+    }
+    if samples[index] != 16383 && samples[index] != -16383 {
+      t.Fatalf("sample %d is %d, want +-16383 at half volume", index, samples[index])
+    }
+  }
+  // the first cycle starts high, so there's no rising edge before it
+  if cycles < 439 || cycles > 440 {
+    t.Errorf("%d cycles in a second, want 440", cycles)
+  }
+}
+
+func TestMute(t *testing.T) {
+  tone := NewTone(440, SINE, 1)
+  tone.SetTone(true)
+  if !tone.ToggleMute() {
+    t.Fatal("ToggleMute should report muted")
+  }
+  for _, sample := range readSecond(t, tone) {
+    if sample != 0 {
+      t.Fatal("sound while muted")
+    }
+  }
+}
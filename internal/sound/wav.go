@@ -0,0 +1,82 @@
+package sound
+
+import (
+  "encoding/binary"
+  "io"
+  "os"
+
+  "jfeintzeig/chip8/internal/cpu"
+)
+
+// one 60Hz frame of audio
+const SAMPLES_PER_FRAME = SAMPLE_RATE / int(cpu.DELAY_SOUND_TIMER_UPDATE)
+
+// A cpu.PatternSink that renders a Tone frame by frame instead of in real
+// time, so headless runs and tests get exactly SAMPLES_PER_FRAME samples
+// of audio for every frame however fast they run.
+type Renderer struct {
+  tone *Tone
+  samples []byte
+}
+
+func NewRenderer(tone *Tone) *Renderer {
+  return &Renderer{tone: tone}
+}
+
+func (r *Renderer) SetPattern(pattern [cpu.AUDIO_PATTERN_SIZE]byte, pitch uint8) {
+  r.tone.SetPattern(pattern, pitch)
+}
+
+// the core calls this at the end of every frame with what should sound
+// until the next one, so that's when we render the next frame's worth
+func (r *Renderer) SetTone(on bool) {
+  r.tone.SetTone(on)
+  frame := make([]byte, SAMPLES_PER_FRAME*BYTES_PER_SAMPLE)
+  r.tone.Read(frame)
+  r.samples = append(r.samples, frame...)
+}
+
+// the audio so far, in the Tone's format
+func (r *Renderer) Samples() []byte {
+  return r.samples
+}
+
+func (r *Renderer) Frames() int {
+  return len(r.samples) / (SAMPLES_PER_FRAME*BYTES_PER_SAMPLE)
+}
+
+func (r *Renderer) WriteWAV(w io.Writer) error {
+  return WriteWAV(w, r.samples)
+}
+
+func (r *Renderer) Save(path string) error {
+  file, err := os.Create(path)
+  if err != nil {
+    return err
+  }
+  defer file.Close()
+  return r.WriteWAV(file)
+}
+
+// writes samples in the Tone's format as a 16 bit stereo PCM WAV file
+func WriteWAV(w io.Writer, samples []byte) error {
+  header := make([]byte, 44)
+  copy(header[0:], "RIFF")
+  binary.LittleEndian.PutUint32(header[4:], uint32(36 + len(samples)))
+  copy(header[8:], "WAVEfmt ")
+  // format chunk: its size, PCM, channels, sample rate, bytes per second, bytes per sample, bits per channel
+  binary.LittleEndian.PutUint32(header[16:], 16)
+  binary.LittleEndian.PutUint16(header[20:], 1)
+  binary.LittleEndian.PutUint16(header[22:], 2)
+  binary.LittleEndian.PutUint32(header[24:], SAMPLE_RATE)
+  binary.LittleEndian.PutUint32(header[28:], SAMPLE_RATE*BYTES_PER_SAMPLE)
+  binary.LittleEndian.PutUint16(header[32:], BYTES_PER_SAMPLE)
+  binary.LittleEndian.PutUint16(header[34:], 16)
+  copy(header[36:], "data")
+  binary.LittleEndian.PutUint32(header[40:], uint32(len(samples)))
+  if _, err := w.Write(header); err != nil {
+    return err
+  }
+  _, err := w.Write(samples)
+  return err
+}
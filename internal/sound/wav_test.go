@@ -0,0 +1,87 @@
+package sound
+
+import (
+  "bytes"
+  "encoding/binary"
+  "math"
+  "testing"
+
+  "jfeintzeig/chip8/internal/cpu"
+  "jfeintzeig/chip8/internal/screen"
+)
+
+// plays a pattern with the first 8 of its 128 bits set for one frame
+func TestPatternPlayback(t *testing.T) {
+  c8 := cpu.NewChip8(false, cpu.MODERN_QUIRKS)
+  copy(c8.Memory()[cpu.PROGRAM_START:], []byte{
+    0xA2, 0x0E, // I = pattern
+    0xF0, 0x02, // load the pattern
+    0x60, 0x40, // V0 = 64
+    0xF0, 0x3A, // pitch = V0, 4000 samples per second
+    0x60, 0x02, // V0 = 2
+    0xF0, 0x18, // sound timer = V0
+    0x12, 0x0C, // loop forever
+    0xFF, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+    0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+  })
+  renderer := NewRenderer(NewTone(DEFAULT_FREQUENCY, SQUARE, 1))
+  c8.Attach(cpu.FrontEnd{Audio: renderer})
+  for frame := 0; frame < 2; frame++ {
+    if err := c8.RunFrame(); err != nil {
+      t.Fatal(err)
+    }
+  }
+  if renderer.Frames() != 2 {
+    t.Fatalf("rendered %d frames, want 2", renderer.Frames())
+  }
+
+  // at 48000Hz each pattern bit lasts 12 samples, so 96 high then the rest
+  // low; the sound timer runs out after one frame
+  samples := renderer.Samples()
+  for index := 0; index < 2*SAMPLES_PER_FRAME; index++ {
+    want := int16(0)
+    if index < 96 {
+      want = math.MaxInt16
+    } else if index < SAMPLES_PER_FRAME {
+      want = -math.MaxInt16
+    }
+    if got := int16(binary.LittleEndian.Uint16(samples[index*BYTES_PER_SAMPLE:])); got != want {
+      t.Fatalf("sample %d is %d, want %d", index, got, want)
+    }
+  }
+
+  var wav bytes.Buffer
+  if err := renderer.WriteWAV(&wav); err != nil {
+    t.Fatal(err)
+  }
+  if wav.Len() != 44 + len(samples) || string(wav.Bytes()[:4]) != "RIFF" || string(wav.Bytes()[36:40]) != "data" {
+    t.Error("bad WAV header")
+  }
+}
+
+func TestPatternRate(t *testing.T) {
+  if PatternRate(64) != 4000 || PatternRate(112) != 8000 || PatternRate(16) != 2000 {
+    t.Errorf("pitch 64, 112 and 16 give %v, %v and %v", PatternRate(64), PatternRate(112), PatternRate(16))
+  }
+}
+
+// recording video and audio from the same core keeps them the same length
+func TestRecordingInSync(t *testing.T) {
+  c8 := cpu.NewChip8(false, cpu.MODERN_QUIRKS)
+  // loop forever on a blank screen, so the video is one long repeated frame
+  copy(c8.Memory()[cpu.PROGRAM_START:], []byte{0x12, 0x00})
+  video := screen.NewRecorder(1, screen.CLASSIC)
+  audio := NewRenderer(NewTone(DEFAULT_FREQUENCY, SQUARE, DEFAULT_VOLUME))
+  c8.Attach(cpu.FrontEnd{Video: video, Audio: audio})
+  for frame := 0; frame < 90; frame++ {
+    if err := c8.RunFrame(); err != nil {
+      t.Fatal(err)
+    }
+  }
+  if video.Ticks() != 90 || audio.Frames() != 90 {
+    t.Errorf("90 frames gave %d ticks of video and %d frames of audio", video.Ticks(), audio.Frames())
+  }
+  if len(audio.Samples()) != 90*SAMPLES_PER_FRAME*BYTES_PER_SAMPLE {
+    t.Errorf("got %d bytes of audio, want 1.5 seconds", len(audio.Samples()))
+  }
+}
@@ -1,7 +1,9 @@
 package utils
 
 import (
-  "log"
+  "errors"
+  "fmt"
+  "strings"
   "text/template"
 )
 
@@ -147,13 +149,28 @@ func (inst *Instruction) SetMnemonicTypeTemplate() {
     case 0x65:
       inst.Mnemonic = "save"
       inst.Type = X
+    // XO-CHIP audio
+    case 0x02:
+      if inst.Full == 0xF002 {
+        inst.Mnemonic = "audio"
+        inst.Type = FULL
+      }
+    case 0x3A:
+      inst.Mnemonic = "pitch"
+      inst.Type = X
     }
   }
 }
 
+// renders the instruction with its template, or as a raw hex word if the
+// bytecode didn't decode to a known instruction (e.g. sprite data)
 func (inst *Instruction) ToString() string {
-  // TODO: use StringTemplate, this should be the same for all instructions
-  return "not implemented"
+  if inst.Mnemonic == "" || inst.Template == nil {
+    return fmt.Sprintf("0x%02X 0x%02X # %04X", inst.Full >> 8, inst.Full & 0xFF, inst.Full)
+  }
+  var sb strings.Builder
+  inst.Template.Execute(&sb, inst)
+  return sb.String()
 }
 
 func (inst *Instruction) ToBytecode() uint16 {
@@ -161,20 +178,26 @@ func (inst *Instruction) ToBytecode() uint16 {
   return 0
 }
 
+// the original interpreter had room for 16 return addresses
+const STACK_SIZE = 16
+
 type Stack []uint16
 
-func (s Stack) Push(val uint16) Stack {
-  if len(s) > 16 {
-    log.Fatal("stack overflow")
+func (s Stack) Push(val uint16) (Stack, error) {
+  if len(s) >= STACK_SIZE {
+    return s, errors.New("stack overflow")
   }
-  return append(s, val)
+  return append(s, val), nil
 }
 
-func (s Stack) Pop() (Stack, uint16) {
+func (s Stack) Pop() (Stack, uint16, error) {
   l := len(s)
+  if l == 0 {
+    return s, 0, errors.New("stack underflow")
+  }
   last := s[l-1]
   s = s[:l-1]
-  return s, last
+  return s, last, nil
 }
 
 // given bytecode, decode and parse instruction
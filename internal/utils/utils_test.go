@@ -0,0 +1,24 @@
+package utils
+
+import (
+  "testing"
+)
+
+func FuzzInstructionFromBytecode(f *testing.F) {
+  f.Add(uint16(0x00E0))
+  f.Add(uint16(0xD01F))
+  f.Add(uint16(0xFFFF))
+  f.Fuzz(func(t *testing.T, codedInstruction uint16) {
+    inst := InstructionFromBytecode(codedInstruction)
+    // the nibbles have to put the instruction back together
+    if uint16(inst.A) << 12 | inst.NNN != codedInstruction {
+      t.Errorf("%04X decoded to A %X and NNN %03X", codedInstruction, inst.A, inst.NNN)
+    }
+    if uint16(inst.X) << 8 | uint16(inst.Y) << 4 | uint16(inst.N) != inst.NNN || uint16(inst.NN) != inst.NNN & 0xFF {
+      t.Errorf("%04X decoded to inconsistent X %X, Y %X, N %X, NN %02X", codedInstruction, inst.X, inst.Y, inst.N, inst.NN)
+    }
+    if inst.ToString() == "" {
+      t.Errorf("%04X rendered as an empty string", codedInstruction)
+    }
+  })
+}
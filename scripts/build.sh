@@ -5,3 +5,5 @@ fi
 
 go build cmd/app/app.go
 go build cmd/disassemble/disassemble.go
+go build cmd/run-headless/run-headless.go
+go build cmd/tui/tui.go
@@ -0,0 +1,13 @@
+#!/bin/bash
+# downloads the conformance ROMs golden_test.go skips when they're missing,
+# from Timendus' CHIP-8 test suite, https://github.com/Timendus/chip8-test-suite
+set -e
+cd "$(dirname "$0")/../internal/cpu/testdata/roms"
+
+url=https://github.com/Timendus/chip8-test-suite/raw/main/bin
+curl -fsSL "$url/3-corax%2B.ch8" -o corax_plus.ch8
+curl -fsSL "$url/4-flags.ch8" -o flags.ch8
+curl -fsSL "$url/5-quirks.ch8" -o quirks.ch8
+curl -fsSL "$url/6-keypad.ch8" -o keypad.ch8
+
+echo "now run go test ./internal/cpu -update and check the new images in internal/cpu/testdata/golden"
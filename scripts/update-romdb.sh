@@ -0,0 +1,13 @@
+#!/bin/bash
+# replaces the ROM database bundled by internal/romdb with the community one
+# from https://github.com/chip-8/chip-8-database, along with its licence
+set -e
+cd "$(dirname "$0")/.."
+
+url=https://raw.githubusercontent.com/chip-8/chip-8-database/master
+for file in programs.json sha1-hashes.json platforms.json; do
+  curl -fsSL "$url/database/$file" -o internal/romdb/data/$file
+done
+curl -fsSL "$url/LICENSE" -o internal/romdb/data/LICENSE
+
+go test ./internal/romdb